@@ -25,6 +25,8 @@ package cmd
 import (
 	"encoding/json"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -41,6 +43,10 @@ var dockerCliPluginMetadataCmd = &cobra.Command{
 		enc.SetIndent("", "    ")
 		// specs: https://docs.docker.com/engine/extend/cli_plugins/#the-docker-cli-plugin-metadata-subcommand
 		d := map[string]string{"SchemaVersion": "0.1.0", "Vendor": "Christian Korneck", "Version": "1.9.0", "ShortDescription": "Push Readme to container registry"}
+		// same executable installed as `docker-pullrm`
+		if strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe") == "docker-pullrm" {
+			d["ShortDescription"] = "Pull Readme from container registry"
+		}
 		enc.Encode(d)
 	},
 }
@@ -0,0 +1,829 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/christian-korneck/docker-pushrm/provider/fakeregistry"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// end-to-end tests: docker-pushrm runs in-process against a fake registry server
+
+// e2eEnv is a fake registry plus a working directory with a README file and an empty Docker config file
+type e2eEnv struct {
+	t        *testing.T
+	registry *fakeregistry.Registry
+	dir      string
+	readme   string
+	config   string
+}
+
+func newE2EEnv(t *testing.T, readme string) *e2eEnv {
+	registry := fakeregistry.New()
+	t.Cleanup(registry.Close)
+
+	dir := t.TempDir()
+	env := &e2eEnv{t: t, registry: registry, dir: dir, readme: filepath.Join(dir, "README.md"), config: filepath.Join(dir, "config.json")}
+	env.writeFile(env.readme, readme)
+	env.writeFile(env.config, "{}")
+
+	for _, provider := range []string{"DOCKERHUB", "QUAY", "HARBOR2", "GITLAB"} {
+		env.setenv("PUSHRM_"+provider+"_URL", registry.URL)
+	}
+	for _, name := range []string{"DOCKER_USER", "DOCKER_PASS", "DOCKER_TOTP", "DOCKER_APIKEY", "GITLAB_TOKEN", "CI_JOB_TOKEN", "CI_API_V4_URL", "PUSHRM_TARGET", "PUSHRM_FILE", "PUSHRM_PROVIDER",
+		"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy", "NO_PROXY", "no_proxy"} {
+		env.setenv(name, "")
+	}
+	return env
+}
+
+// setenv sets an env var for the duration of the test (an empty value unsets it)
+func (e *e2eEnv) setenv(name string, value string) {
+	old, existed := os.LookupEnv(name)
+	e.t.Cleanup(func() {
+		if existed {
+			os.Setenv(name, old)
+		} else {
+			os.Unsetenv(name)
+		}
+	})
+	if value == "" {
+		os.Unsetenv(name)
+	} else {
+		os.Setenv(name, value)
+	}
+}
+
+// stdin replaces stdin with content for the duration of the test
+func (e *e2eEnv) stdin(content string) {
+	path := filepath.Join(e.dir, "stdin")
+	e.writeFile(path, content)
+	f, err := os.Open(path)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	origStdin := os.Stdin
+	os.Stdin = f
+	e.t.Cleanup(func() {
+		os.Stdin = origStdin
+		f.Close()
+	})
+}
+
+// fakeCredHelper is a Docker credential helper ("docker-credential-pushrmtest") that keeps credentials in files
+const fakeCredHelper = `#!/bin/sh
+dir="$(dirname "$0")/creds"
+mkdir -p "$dir"
+case "$1" in
+store)
+	input=$(cat)
+	key=$(printf '%s' "$input" | sed 's/.*"ServerURL":"\([^"]*\)".*/\1/')
+	printf '%s' "$input" > "$dir/$(printf '%s' "$key" | tr -c 'a-zA-Z0-9' '_')"
+	;;
+get)
+	key=$(cat)
+	file="$dir/$(printf '%s' "$key" | tr -c 'a-zA-Z0-9' '_')"
+	[ -f "$file" ] || { echo "credentials not found in native keychain"; exit 1; }
+	cat "$file"
+	;;
+erase)
+	key=$(cat)
+	file="$dir/$(printf '%s' "$key" | tr -c 'a-zA-Z0-9' '_')"
+	[ -f "$file" ] || { echo "credentials not found in native keychain"; exit 1; }
+	rm "$file"
+	;;
+esac
+`
+
+// installCredHelper puts the fake credential helper on the PATH
+func (e *e2eEnv) installCredHelper() {
+	if runtime.GOOS == "windows" {
+		e.t.Skip("the fake credential helper is a shell script")
+	}
+	bin := filepath.Join(e.dir, "bin")
+	if err := os.Mkdir(bin, 0755); err != nil {
+		e.t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(bin, "docker-credential-pushrmtest"), []byte(fakeCredHelper), 0755); err != nil {
+		e.t.Fatal(err)
+	}
+	e.setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func (e *e2eEnv) writeFile(path string, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+// run calls docker-pushrm with args (i.e. "pushrm", "my-user/my-repo") and returns its stdout and exit code
+func (e *e2eEnv) run(args ...string) (stdout string, code int) {
+	for _, cmd := range []*cobra.Command{rootCmd, pushrmCmd, pullrmCmd, loginCmd, logoutCmd} {
+		resetFlags(cmd)
+	}
+	// keep the backoff of retries short (unless the test sets "--retry-max-wait", the last value wins)
+	rootCmd.SetArgs(append([]string{args[0], "--config", e.config, "--retry-max-wait", "10ms"}, args[1:]...))
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	origStdout := os.Stdout
+	os.Stdout = w
+	output := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		output <- string(data)
+	}()
+
+	err = rootCmd.Execute()
+
+	w.Close()
+	os.Stdout = origStdout
+	stdout = <-output
+
+	var exit exitError
+	switch {
+	case err == nil:
+		code = 0
+	case errors.As(err, &exit):
+		code = exit.code
+	default:
+		code = 1
+	}
+	e.t.Logf("docker-pushrm %s: exit code %d, output:\n%s", strings.Join(args, " "), code, stdout)
+	return stdout, code
+}
+
+// resetFlags sets all flags of a command back to their defaults (flag values survive between runs)
+func resetFlags(cmd *cobra.Command) {
+	reset := func(f *pflag.Flag) {
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			slice.Replace(nil)
+		} else {
+			f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	}
+	cmd.Flags().VisitAll(reset)
+	cmd.PersistentFlags().VisitAll(reset)
+	cmd.SilenceErrors = false
+	cmd.SilenceUsage = false
+}
+
+func (e *e2eEnv) expectRepo(path string, readme string, shortdesc string) {
+	repo, ok := e.registry.Repo(path)
+	if !ok {
+		e.t.Fatalf("repo %s doesn't exist", path)
+	}
+	if repo.Readme != readme || repo.Shortdesc != shortdesc {
+		e.t.Errorf("repo %s: got readme %q, short description %q, want %q, %q", path, repo.Readme, repo.Shortdesc, readme, shortdesc)
+	}
+}
+
+func expectCode(t *testing.T, got int, want int) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("got exit code %d, want %d", got, want)
+	}
+}
+
+func expectOutput(t *testing.T, stdout string, want string) {
+	t.Helper()
+	if !strings.Contains(stdout, want) {
+		t.Errorf("output doesn't contain %q:\n%s", want, stdout)
+	}
+}
+
+func TestE2EDockerhub(t *testing.T) {
+	env := newE2EEnv(t, "# hello\n")
+	env.registry.AddUser("my-user", "my-password")
+	env.registry.AddRepo("my-user/my-repo", fakeregistry.Repo{Readme: "old"})
+	env.setenv("DOCKER_USER", "my-user")
+	env.setenv("DOCKER_PASS", "my-password")
+
+	stdout, code := env.run("pushrm", "--file", env.readme, "--short", "my short description", "my-user/my-repo")
+	expectCode(t, code, 0)
+	expectOutput(t, stdout, "docker.io/my-user/my-repo: updated")
+	env.expectRepo("my-user/my-repo", "# hello\n", "my short description")
+
+	// same content again: nothing gets written
+	stdout, code = env.run("pushrm", "--file", env.readme, "--short", "my short description", "docker.io/my-user/my-repo:latest")
+	expectCode(t, code, 0)
+	expectOutput(t, stdout, "unchanged")
+	if writes := env.registry.Writes(); writes != 1 {
+		t.Errorf("got %d writes, want 1", writes)
+	}
+}
+
+func TestE2EDockerhubBadCredentials(t *testing.T) {
+	env := newE2EEnv(t, "# hello\n")
+	env.registry.AddUser("my-user", "my-password")
+	env.registry.AddRepo("my-user/my-repo", fakeregistry.Repo{Readme: "old"})
+	env.setenv("DOCKER_USER", "my-user")
+	env.setenv("DOCKER_PASS", "wrong")
+
+	_, code := env.run("pushrm", "--file", env.readme, "my-user/my-repo")
+	expectCode(t, code, exitCodeAuth)
+	env.expectRepo("my-user/my-repo", "old", "")
+}
+
+func TestE2EDockerhub2FA(t *testing.T) {
+	env := newE2EEnv(t, "# hello\n")
+	env.registry.AddUser("my-user", "my-password")
+	env.registry.Enable2FA("my-user", "123456")
+	env.registry.AddRepo("my-user/my-repo", fakeregistry.Repo{})
+	env.setenv("DOCKER_USER", "my-user")
+	env.setenv("DOCKER_PASS", "my-password")
+	env.setenv("DOCKER_TOTP", "123456")
+
+	_, code := env.run("pushrm", "--file", env.readme, "my-user/my-repo")
+	expectCode(t, code, 0)
+	env.expectRepo("my-user/my-repo", "# hello\n", "")
+}
+
+func TestE2EDockerhubOrgAccessToken(t *testing.T) {
+	env := newE2EEnv(t, "# hello\n")
+	env.registry.AddToken("dckr_oat_secret", "my-org")
+	env.registry.AddRepo("my-org/my-repo", fakeregistry.Repo{})
+	env.setenv("DOCKER_USER", "my-org")
+	env.setenv("DOCKER_PASS", "dckr_oat_secret")
+
+	_, code := env.run("pushrm", "--file", env.readme, "my-org/my-repo")
+	expectCode(t, code, 0)
+	env.expectRepo("my-org/my-repo", "# hello\n", "")
+}
+
+func TestE2EDockerhubReadOnly(t *testing.T) {
+	env := newE2EEnv(t, "# hello\n")
+	env.registry.AddUser("my-user", "my-password")
+	env.registry.SetReadOnly("my-user")
+	env.registry.AddRepo("my-user/my-repo", fakeregistry.Repo{Readme: "old"})
+	env.setenv("DOCKER_USER", "my-user")
+	env.setenv("DOCKER_PASS", "my-password")
+
+	_, code := env.run("pushrm", "--file", env.readme, "my-user/my-repo")
+	expectCode(t, code, exitCodeAuth)
+	env.expectRepo("my-user/my-repo", "old", "")
+}
+
+func TestE2EQuay(t *testing.T) {
+	env := newE2EEnv(t, "# hello quay\n")
+	env.registry.AddToken("my-apikey", "my-user")
+	env.registry.AddRepo("my-org/my-repo", fakeregistry.Repo{})
+	env.setenv("APIKEY__QUAY_EXAMPLE_COM", "my-apikey")
+
+	stdout, code := env.run("pushrm", "--file", env.readme, "--provider", "quay", "quay.example.com/my-org/my-repo")
+	expectCode(t, code, 0)
+	expectOutput(t, stdout, "quay.example.com/my-org/my-repo: updated")
+	env.expectRepo("my-org/my-repo", "# hello quay\n", "")
+}
+
+func TestE2EHarbor(t *testing.T) {
+	env := newE2EEnv(t, "# hello harbor\n")
+	env.registry.AddUser("robot$my-project", "my-password")
+	env.registry.AddRepo("my-project/my-team/my-repo", fakeregistry.Repo{})
+	env.setenv("DOCKER_USER__HARBOR_EXAMPLE_COM_8443", "robot$my-project")
+	env.setenv("DOCKER_PASS__HARBOR_EXAMPLE_COM_8443", "my-password")
+
+	_, code := env.run("pushrm", "--file", env.readme, "--provider", "harbor2", "harbor.example.com:8443/my-project/my-team/my-repo")
+	expectCode(t, code, 0)
+	env.expectRepo("my-project/my-team/my-repo", "# hello harbor\n", "")
+}
+
+func TestE2EIdentityToken(t *testing.T) {
+	env := newE2EEnv(t, "# hello sso\n")
+	env.registry.AddRepo("my-user/my-repo", fakeregistry.Repo{})
+	env.registry.AddRepo("my-project/my-repo", fakeregistry.Repo{})
+	// identity tokens of an SSO login (no username/password)
+	env.writeFile(env.config, `{"auths": {
+		"https://index.docker.io/v1/": {"identitytoken": "my-refresh-token"},
+		"harbor.example.com": {"identitytoken": "my-refresh-token"}
+	}}`)
+
+	// identity tokens aren't supported, they are rejected before any api call
+	_, code := env.run("pushrm", "--file", env.readme, "my-user/my-repo")
+	expectCode(t, code, exitCodeAuth)
+	env.expectRepo("my-user/my-repo", "", "")
+
+	_, code = env.run("pushrm", "--file", env.readme, "--provider", "harbor2", "harbor.example.com/my-project/my-repo")
+	expectCode(t, code, exitCodeAuth)
+	env.expectRepo("my-project/my-repo", "", "")
+
+	if requests := env.registry.Requests(); len(requests) > 0 {
+		t.Errorf("got requests %v, want none", requests)
+	}
+}
+
+func TestE2ECredsStoreFallback(t *testing.T) {
+	env := newE2EEnv(t, "# hello\n")
+	env.installCredHelper()
+	env.registry.AddUser("my-user", "my-password")
+	env.registry.AddRepo("my-user/my-repo", fakeregistry.Repo{})
+	// the credential helper has no entry for Dockerhub, the inline credentials are used
+	env.writeFile(env.config, `{"credsStore": "pushrmtest", "auths": {
+		"https://index.docker.io/v1/": {"auth": "`+base64.StdEncoding.EncodeToString([]byte("my-user:my-password"))+`"}
+	}}`)
+
+	_, code := env.run("pushrm", "--file", env.readme, "my-user/my-repo")
+	expectCode(t, code, 0)
+	env.expectRepo("my-user/my-repo", "# hello\n", "")
+}
+
+func TestE2EGitlab(t *testing.T) {
+	env := newE2EEnv(t, "# hello gitlab\n")
+	env.registry.AddToken("my-token", "my-user")
+	env.registry.AddRepo("my-group/my-project", fakeregistry.Repo{})
+	env.registry.AddImage("my-group/my-project/my-image")
+	env.setenv("GITLAB_TOKEN", "my-token")
+
+	_, code := env.run("pushrm", "--file", env.readme, "--provider", "gitlab", "registry.example.com/my-group/my-project/my-image")
+	expectCode(t, code, 0)
+	env.expectRepo("my-group/my-project", "# hello gitlab\n", "")
+
+	// the project exists, but has no such image
+	env.writeFile(env.readme, "# other image\n")
+	_, code = env.run("pushrm", "--file", env.readme, "--provider", "gitlab", "registry.example.com/my-group/my-project/other-image")
+	expectCode(t, code, exitCodeNotFound)
+	env.expectRepo("my-group/my-project", "# hello gitlab\n", "")
+}
+
+func TestE2EGitlabJobToken(t *testing.T) {
+	env := newE2EEnv(t, "# hello gitlab\n")
+	env.registry.AddToken("my-job-token", "my-user")
+	env.registry.AddRepo("my-group/my-project", fakeregistry.Repo{Readme: "old"})
+	env.setenv("CI_JOB_TOKEN", "my-job-token")
+
+	pulled := env.readme + ".pulled"
+	_, code := env.run("pullrm", "--file", pulled, "--provider", "gitlab", "registry.example.com/my-group/my-project")
+	expectCode(t, code, 0)
+	if data, _ := ioutil.ReadFile(pulled); string(data) != "old" {
+		t.Errorf("got %q, want %q", string(data), "old")
+	}
+
+	// job tokens can't edit project descriptions
+	_, code = env.run("pushrm", "--file", env.readme, "--provider", "gitlab", "registry.example.com/my-group/my-project")
+	expectCode(t, code, exitCodeAuth)
+	env.expectRepo("my-group/my-project", "old", "")
+}
+
+func TestE2EDryRun(t *testing.T) {
+	env := newE2EEnv(t, "# hello\nnew line\n")
+	env.registry.AddUser("my-user", "my-password")
+	env.registry.AddRepo("my-user/my-repo", fakeregistry.Repo{Readme: "# hello\nold line\n"})
+	env.setenv("DOCKER_USER", "my-user")
+	env.setenv("DOCKER_PASS", "my-password")
+
+	stdout, code := env.run("pushrm", "--file", env.readme, "--dry-run", "my-user/my-repo")
+	expectCode(t, code, exitCodeDiffers)
+	expectOutput(t, stdout, "-old line\n+new line\n")
+	if writes := env.registry.Writes(); writes != 0 {
+		t.Errorf("dry-run wrote %d times", writes)
+	}
+
+	env.writeFile(env.readme, "# hello\nold line\n")
+	_, code = env.run("pushrm", "--file", env.readme, "--diff", "my-user/my-repo")
+	expectCode(t, code, 0)
+}
+
+func TestE2EMultipleTargets(t *testing.T) {
+	env := newE2EEnv(t, "# hello\n")
+	env.registry.AddUser("my-user", "my-password")
+	env.registry.AddRepo("my-user/my-repo", fakeregistry.Repo{})
+	env.registry.AddRepo("my-project/my-repo", fakeregistry.Repo{})
+	env.setenv("DOCKER_USER", "my-user")
+	env.setenv("DOCKER_PASS", "my-password")
+
+	stdout, code := env.run("pushrm", "--file", env.readme, "--provider", "harbor2", "docker.io/my-user/my-repo", "harbor.example.com/my-project/my-repo", "harbor.example.com/my-project/missing")
+	expectCode(t, code, exitCodeNotFound)
+	expectOutput(t, stdout, "3 targets: 2 updated, 0 unchanged, 1 failed")
+	env.expectRepo("my-user/my-repo", "# hello\n", "")
+	env.expectRepo("my-project/my-repo", "# hello\n", "")
+}
+
+func TestE2EJSONOutput(t *testing.T) {
+	env := newE2EEnv(t, "# hello\n")
+	env.registry.AddUser("my-user", "my-password")
+	env.registry.AddRepo("my-user/my-repo", fakeregistry.Repo{Readme: "# hello\n"})
+	env.registry.AddRepo("my-user/other-repo", fakeregistry.Repo{})
+	env.setenv("DOCKER_USER", "my-user")
+	env.setenv("DOCKER_PASS", "my-password")
+
+	stdout, code := env.run("pushrm", "--file", env.readme, "--output", "json", "--short", "short", "my-user/my-repo", "my-user/other-repo", "my-user/missing")
+	expectCode(t, code, exitCodeNotFound)
+
+	var results []jsonResult
+	if err := json.Unmarshal([]byte(stdout), &results); err != nil {
+		t.Fatalf("invalid json output: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	// the short description differs, both get written
+	want := []jsonResult{
+		{Target: "docker.io/my-user/my-repo", Provider: "dockerhub", Action: "updated", BytesPushed: 13, URL: "https://hub.docker.com/r/my-user/my-repo"},
+		{Target: "docker.io/my-user/other-repo", Provider: "dockerhub", Action: "updated", BytesPushed: 13, URL: "https://hub.docker.com/r/my-user/other-repo"},
+		{Target: "docker.io/my-user/missing", Provider: "dockerhub", Action: "failed", ErrorCategory: categoryNotFound},
+	}
+	for i, result := range results {
+		result.DurationMs = 0
+		result.Error = ""
+		if result != want[i] {
+			t.Errorf("got result %+v, want %+v", result, want[i])
+		}
+	}
+}
+
+func TestE2EExitCodes(t *testing.T) {
+	env := newE2EEnv(t, "# hello\n")
+	env.registry.AddUser("my-user", "my-password")
+	env.setenv("DOCKER_USER", "my-user")
+	env.setenv("DOCKER_PASS", "my-password")
+
+	_, code := env.run("pushrm", "--file", env.readme, "--provider", "unknown", "registry.example.com/my-user/my-repo")
+	expectCode(t, code, exitCodeUsage)
+
+	_, code = env.run("pushrm", "--file", env.readme, "--output", "yaml", "my-user/my-repo")
+	expectCode(t, code, exitCodeUsage)
+
+	_, code = env.run("pushrm", "--all", "my-user/my-repo")
+	expectCode(t, code, exitCodeUsage)
+
+	_, code = env.run("pushrm", "--file", env.readme, "--only", "web", "my-user/my-repo")
+	expectCode(t, code, exitCodeUsage)
+
+	_, code = env.run("pushrm", "--file", env.readme, "--retry-max-wait", "-1s", "my-user/my-repo")
+	expectCode(t, code, exitCodeUsage)
+
+	_, code = env.run("pullrm", "--file", env.readme+".pulled", "--retries", "-1", "my-user/my-repo")
+	expectCode(t, code, exitCodeUsage)
+
+	_, code = env.run("pushrm", "--file", env.readme)
+	expectCode(t, code, exitCodeUsage)
+
+	_, code = env.run("pullrm", "--file", env.readme+".pulled")
+	expectCode(t, code, exitCodeUsage)
+
+	_, code = env.run("pushrm", "--file", env.readme, "my-user/missing")
+	expectCode(t, code, exitCodeNotFound)
+
+	// nothing listens on this port
+	env.setenv("PUSHRM_DOCKERHUB_URL", "http://127.0.0.1:1")
+	_, code = env.run("pushrm", "--file", env.readme, "my-user/my-repo")
+	expectCode(t, code, exitCodeNetwork)
+}
+
+func TestE2ERetry(t *testing.T) {
+	env := newE2EEnv(t, "# hello\n")
+	env.registry.AddUser("my-user", "my-password")
+	env.registry.AddRepo("my-user/my-repo", fakeregistry.Repo{})
+	env.setenv("DOCKER_USER", "my-user")
+	env.setenv("DOCKER_PASS", "my-password")
+
+	// the login (POST) is marked as safe to retry
+	env.registry.FailNext(2, 502, nil)
+	_, code := env.run("pushrm", "--file", env.readme, "my-user/my-repo")
+	expectCode(t, code, 0)
+	env.expectRepo("my-user/my-repo", "# hello\n", "")
+
+	env.writeFile(env.readme, "# rate limited\n")
+	env.registry.FailNext(1, 429, http.Header{"Retry-After": []string{"0"}})
+	_, code = env.run("pushrm", "--file", env.readme, "my-user/my-repo")
+	expectCode(t, code, 0)
+	env.expectRepo("my-user/my-repo", "# rate limited\n", "")
+
+	// retries used up (2 attempts for the read, 2 for the write)
+	env.writeFile(env.readme, "# unavailable\n")
+	env.registry.FailNext(4, 503, nil)
+	_, code = env.run("pushrm", "--file", env.readme, "--retries", "1", "my-user/my-repo")
+	expectCode(t, code, exitCodeNetwork)
+	env.expectRepo("my-user/my-repo", "# rate limited\n", "")
+
+	// the server asks to wait longer than "--retry-max-wait"
+	requests := len(env.registry.Requests())
+	env.registry.FailNext(1, 429, http.Header{"X-Ratelimit-Remaining": []string{"0"}, "X-Ratelimit-Reset": []string{"4102444800"}})
+	env.registry.FailNext(1, 429, http.Header{"Retry-After": []string{"3600"}})
+	_, code = env.run("pushrm", "--file", env.readme, "my-user/my-repo")
+	expectCode(t, code, exitCodeNetwork)
+	if got := len(env.registry.Requests()); got != requests {
+		t.Errorf("got %d requests after the rate limit, want none", got-requests)
+	}
+}
+
+func TestE2ETimeout(t *testing.T) {
+	env := newE2EEnv(t, "# hello\n")
+	env.registry.AddUser("my-user", "my-password")
+	env.registry.AddRepo("my-user/my-repo", fakeregistry.Repo{})
+	env.setenv("DOCKER_USER", "my-user")
+	env.setenv("DOCKER_PASS", "my-password")
+	env.registry.SetDelay(time.Second)
+
+	_, code := env.run("pushrm", "--file", env.readme, "--request-timeout", "50ms", "--retries", "0", "my-user/my-repo")
+	expectCode(t, code, exitCodeTimeout)
+
+	// the total timeout also ends retries
+	start := time.Now()
+	_, code = env.run("pushrm", "--file", env.readme, "--timeout", "100ms", "--request-timeout", "0", "--retries", "5", "my-user/my-repo")
+	expectCode(t, code, exitCodeTimeout)
+	if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+		t.Errorf("took %s, want less than the server delay", elapsed)
+	}
+
+	stdout, code := env.run("pushrm", "--file", env.readme, "--output", "json", "--timeout", "100ms", "my-user/my-repo")
+	expectCode(t, code, exitCodeTimeout)
+	expectOutput(t, stdout, `"error_category": "timeout"`)
+	env.expectRepo("my-user/my-repo", "", "")
+}
+
+// newE2EProxy starts an http proxy that forwards requests to the fake registry and counts them. Requests without
+// the proxy credentials user:password are rejected.
+func newE2EProxy(t *testing.T, registry *fakeregistry.Registry) (proxyURL string, requests *int32) {
+	target, err := url.Parse(registry.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests = new(int32)
+	forward := httputil.NewSingleHostReverseProxy(target)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodConnect {
+			// no https
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get("Proxy-Authorization") != "Basic dXNlcjpwYXNzd29yZA==" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		atomic.AddInt32(requests, 1)
+		r.Header.Del("Proxy-Authorization")
+		forward.ServeHTTP(w, r)
+	}))
+	t.Cleanup(proxy.Close)
+	return strings.Replace(proxy.URL, "http://", "http://user:password@", 1), requests
+}
+
+func TestE2EProxy(t *testing.T) {
+	env := newE2EEnv(t, "# hello\n")
+	env.registry.AddUser("my-user", "my-password")
+	env.registry.AddRepo("my-user/my-repo", fakeregistry.Repo{})
+	env.setenv("DOCKER_USER", "my-user")
+	env.setenv("DOCKER_PASS", "my-password")
+	// a host that only the proxy can reach (requests to localhost are never proxied)
+	env.setenv("PUSHRM_DOCKERHUB_URL", "http://hub.example.invalid")
+	proxyURL, requests := newE2EProxy(t, env.registry)
+
+	_, code := env.run("pushrm", "--file", env.readme, "--proxy", proxyURL, "my-user/my-repo")
+	expectCode(t, code, 0)
+	env.expectRepo("my-user/my-repo", "# hello\n", "")
+	if atomic.LoadInt32(requests) == 0 {
+		t.Error("no requests went through the proxy")
+	}
+
+	// proxy from the Docker config file
+	env.writeFile(env.readme, "# from config\n")
+	env.writeFile(env.config, `{"proxies": {"default": {"httpProxy": "`+proxyURL+`", "noProxy": "localhost,.internal.example.com"}}}`)
+	_, code = env.run("pushrm", "--file", env.readme, "my-user/my-repo")
+	expectCode(t, code, 0)
+	env.expectRepo("my-user/my-repo", "# from config\n", "")
+
+	// env vars take precedence over the config file
+	env.setenv("HTTP_PROXY", strings.Replace(proxyURL, "password", "wrong", 1))
+	_, code = env.run("pushrm", "--file", env.readme, "--retries", "0", "my-user/my-repo")
+	expectCode(t, code, exitCodeError)
+
+	// hosts in "--no-proxy" are reached directly (and can't be resolved)
+	before := atomic.LoadInt32(requests)
+	_, code = env.run("pushrm", "--file", env.readme, "--proxy", proxyURL, "--no-proxy", "example.invalid", "--retries", "0", "my-user/my-repo")
+	expectCode(t, code, exitCodeNetwork)
+	if got := atomic.LoadInt32(requests); got != before {
+		t.Errorf("got %d requests through the proxy, want none", got-before)
+	}
+}
+
+func TestE2EInsecureRegistry(t *testing.T) {
+	env := newE2EEnv(t, "# hello insecure\n")
+	env.registry.AddToken("my-apikey", "my-user")
+	env.registry.AddRepo("my-org/my-repo", fakeregistry.Repo{})
+	env.setenv("PUSHRM_QUAY_URL", "")
+	env.setenv("DOCKER_APIKEY", "my-apikey")
+
+	// registries on loopback addresses aren't insecure by default
+	servername := strings.TrimPrefix(env.registry.URL, "http://")
+	_, code := env.run("pushrm", "--file", env.readme, "--provider", "quay", "--retries", "0", servername+"/my-org/my-repo")
+	expectCode(t, code, exitCodeNetwork)
+
+	// https fails and plain http is used
+	stdout, code := env.run("pushrm", "--file", env.readme, "--provider", "quay", "--output", "json", "--insecure-registry", "127.0.0.0/8", servername+"/my-org/my-repo")
+	expectCode(t, code, 0)
+	expectOutput(t, stdout, `"url": "http://`+servername+`/repository/my-org/my-repo"`)
+	env.expectRepo("my-org/my-repo", "# hello insecure\n", "")
+
+	// other registries need "--insecure-registry" (the proxy only forwards plain http requests)
+	proxyURL, _ := newE2EProxy(t, env.registry)
+	env.writeFile(env.readme, "# hello proxy\n")
+	_, code = env.run("pushrm", "--file", env.readme, "--provider", "quay", "--proxy", proxyURL, "--retries", "0", "quay.example.invalid/my-org/my-repo")
+	expectCode(t, code, exitCodeNetwork)
+	_, code = env.run("pushrm", "--file", env.readme, "--provider", "quay", "--proxy", proxyURL, "--insecure-registry", "quay.example.invalid", "quay.example.invalid/my-org/my-repo")
+	expectCode(t, code, 0)
+	env.expectRepo("my-org/my-repo", "# hello proxy\n", "")
+}
+
+func TestE2ELogin(t *testing.T) {
+	env := newE2EEnv(t, "# hello login\n")
+	env.installCredHelper()
+	env.registry.AddToken("my-apikey", "my-user")
+	env.registry.AddRepo("my-org/my-repo", fakeregistry.Repo{})
+
+	// without a credential helper the api key isn't stored (no plaintext)
+	env.stdin("my-apikey\n")
+	_, code := env.run("pushrm", "login", "--token-stdin", "quay.example.com")
+	expectCode(t, code, exitCodeError)
+
+	env.writeFile(env.config, `{"credsStore": "pushrmtest"}`)
+	env.stdin("wrong\n")
+	_, code = env.run("pushrm", "login", "--token-stdin", "quay.example.com")
+	expectCode(t, code, exitCodeAuth)
+
+	// Dockerhub uses "docker login"
+	env.stdin("my-apikey\n")
+	_, code = env.run("pushrm", "login", "--token-stdin", "docker.io")
+	expectCode(t, code, exitCodeUsage)
+
+	env.stdin("my-apikey\n")
+	stdout, code := env.run("pushrm", "login", "--token-stdin", "quay.example.com")
+	expectCode(t, code, 0)
+	expectOutput(t, stdout, "Login Succeeded")
+	if config, _ := ioutil.ReadFile(env.config); strings.Contains(string(config), "my-apikey") {
+		t.Errorf("api key was written to the config file: %s", config)
+	}
+	// stored under an https url (osxkeychain doesn't accept other schemes)
+	if _, err := os.Stat(filepath.Join(env.dir, "bin", "creds", "https___quay_example_com_docker_pushrm")); err != nil {
+		t.Errorf("api key not stored under https://quay.example.com/docker-pushrm: %v", err)
+	}
+
+	_, code = env.run("pushrm", "--file", env.readme, "--provider", "quay", "quay.example.com/my-org/my-repo")
+	expectCode(t, code, 0)
+	env.expectRepo("my-org/my-repo", "# hello login\n", "")
+
+	// GitLab reads the token from the same place
+	env.registry.AddRepo("my-group/my-project", fakeregistry.Repo{})
+	env.stdin("my-apikey\n")
+	_, code = env.run("pushrm", "login", "--provider", "gitlab", "--token-stdin", "registry.example.com")
+	expectCode(t, code, 0)
+	_, code = env.run("pushrm", "--file", env.readme, "--provider", "gitlab", "registry.example.com/my-group/my-project")
+	expectCode(t, code, 0)
+	env.expectRepo("my-group/my-project", "# hello login\n", "")
+
+	_, code = env.run("pushrm", "logout", "quay.example.com")
+	expectCode(t, code, 0)
+	_, code = env.run("pushrm", "--file", env.readme, "--provider", "quay", "quay.example.com/my-org/my-repo")
+	expectCode(t, code, exitCodeAuth)
+	_, code = env.run("pushrm", "logout", "quay.example.com")
+	expectCode(t, code, exitCodeError)
+}
+
+func TestE2EManifest(t *testing.T) {
+	// the README next to the manifest is the default file
+	env := newE2EEnv(t, "# default\n")
+	env.registry.AddUser("my-user", "my-password")
+	env.registry.AddRepo("my-user/web", fakeregistry.Repo{})
+	env.registry.AddRepo("my-user/worker", fakeregistry.Repo{})
+	env.registry.AddRepo("my-user/base", fakeregistry.Repo{})
+	env.setenv("DOCKER_USER", "my-user")
+	env.setenv("DOCKER_PASS", "my-password")
+
+	os.MkdirAll(filepath.Join(env.dir, "web"), 0755)
+	os.MkdirAll(filepath.Join(env.dir, "worker"), 0755)
+	env.writeFile(filepath.Join(env.dir, "web", "README.md"), "# web\n")
+	env.writeFile(filepath.Join(env.dir, "worker", "README.md"), "# worker\n")
+	manifest := filepath.Join(env.dir, ".pushrm.yaml")
+	env.writeFile(manifest, `images:
+  - name: web
+    file: web/README.md
+    short: the web frontend
+    targets:
+      - my-user/web
+  - name: worker
+    file: worker/README.md
+    targets:
+      - my-user/worker
+  - name: base
+    targets:
+      - my-user/base
+`)
+
+	_, code := env.run("pushrm", "--all", "--manifest", manifest, "--only", "web")
+	expectCode(t, code, 0)
+	env.expectRepo("my-user/web", "# web\n", "the web frontend")
+	env.expectRepo("my-user/worker", "", "")
+
+	_, code = env.run("pushrm", "--all", "--manifest", manifest)
+	expectCode(t, code, 0)
+	env.expectRepo("my-user/worker", "# worker\n", "")
+	env.expectRepo("my-user/base", "# default\n", "")
+
+	// "--short" is the default for images without a short description
+	_, code = env.run("pushrm", "--all", "--manifest", manifest, "--short", "my short")
+	expectCode(t, code, 0)
+	env.expectRepo("my-user/web", "# web\n", "the web frontend")
+	env.expectRepo("my-user/worker", "# worker\n", "my short")
+
+	_, code = env.run("pushrm", "--all", "--manifest", manifest, "--only", "unknown")
+	expectCode(t, code, exitCodeUsage)
+
+	invalid := filepath.Join(env.dir, "invalid.yaml")
+	env.writeFile(invalid, "images:\n  - name: web\n")
+	_, code = env.run("pushrm", "--all", "--manifest", invalid)
+	expectCode(t, code, exitCodeUsage)
+}
+
+func TestE2EPullrm(t *testing.T) {
+	env := newE2EEnv(t, "")
+	env.registry.AddUser("my-user", "my-password")
+	env.registry.AddRepo("my-user/my-repo", fakeregistry.Repo{Readme: "# remote\n"})
+	env.setenv("DOCKER_USER", "my-user")
+	env.setenv("DOCKER_PASS", "my-password")
+
+	// don't overwrite existing files without --force
+	_, code := env.run("pullrm", "--file", env.readme, "my-user/my-repo")
+	expectCode(t, code, 1)
+
+	_, code = env.run("pullrm", "--file", env.readme, "--force", "my-user/my-repo")
+	expectCode(t, code, 0)
+	if data, _ := ioutil.ReadFile(env.readme); string(data) != "# remote\n" {
+		t.Errorf("got %q, want %q", string(data), "# remote\n")
+	}
+}
+
+func TestE2ESectionEnv(t *testing.T) {
+	env := newE2EEnv(t, "# Intro\n\nintro\n\n## Getting Started\n\nsteps\n\n## Usage\n\nuse\n\n## License\n\nMIT\n")
+	env.registry.AddUser("my-user", "my-password")
+	env.registry.AddRepo("my-user/my-repo", fakeregistry.Repo{})
+	env.setenv("DOCKER_USER", "my-user")
+	env.setenv("DOCKER_PASS", "my-password")
+	// a comma separated list, section names can contain spaces
+	env.setenv("PUSHRM_SECTION", "Getting Started, Usage")
+
+	_, code := env.run("pushrm", "--file", env.readme, "my-user/my-repo")
+	expectCode(t, code, 0)
+	env.expectRepo("my-user/my-repo", "## Getting Started\n\nsteps\n\n## Usage\n\nuse\n\n", "")
+}
+
+func TestE2ESetEnv(t *testing.T) {
+	env := newE2EEnv(t, "# plain\n")
+	env.registry.AddUser("my-user", "my-password")
+	env.registry.AddRepo("my-user/my-repo", fakeregistry.Repo{})
+	env.registry.AddRepo("my-user/my-template", fakeregistry.Repo{})
+	env.setenv("DOCKER_USER", "my-user")
+	env.setenv("DOCKER_PASS", "my-password")
+	// a comma separated list, values can contain spaces and "="
+	env.setenv("PUSHRM_SET", "title=Hello World,args=a=b")
+
+	// the values are checked for every push, also without template
+	_, code := env.run("pushrm", "--file", env.readme, "my-user/my-repo")
+	expectCode(t, code, 0)
+	env.expectRepo("my-user/my-repo", "# plain\n", "")
+
+	template := filepath.Join(env.dir, "README.md.tmpl")
+	env.writeFile(template, "# {{ .Values.title }}\n\n{{ .Values.args }}\n")
+	_, code = env.run("pushrm", "--file", template, "my-user/my-template")
+	expectCode(t, code, 0)
+	env.expectRepo("my-user/my-template", "# Hello World\n\na=b\n", "")
+}
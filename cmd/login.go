@@ -0,0 +1,195 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/christian-korneck/docker-pushrm/provider/provider"
+	"github.com/christian-korneck/docker-pushrm/util"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var loginProvider string
+var tokenStdin bool
+
+// loginCmd represents the login command
+var loginCmd = &cobra.Command{
+	Use:   "login SERVER",
+	Args:  cobra.ExactArgs(1),
+	Short: "store the api key for a registry server (quay, gitlab) in the Docker credentials store",
+	Long: `help for docker pushrm login
+
+	docker pushrm login SERVER [flags]
+
+	prompts for the api key of a registry server, checks it against
+	the api of the server and stores it with the Docker credential
+	helper ("credsStore" or "credHelpers" in the Docker config file),
+	under the server url "https://SERVER/docker-pushrm". 'docker pushrm'
+	and 'docker pullrm' read it from there.
+
+	This is needed for providers that don't use the Docker login
+	(quay: api key, gitlab: access token). Dockerhub and Harbor use
+	the login of 'docker login'. The api key is never written to the
+	Docker config file in plaintext, a credential helper is required.
+
+	The provider is detected for quay.io and registry.gitlab.com,
+	otherwise it's set with '--provider' (default: quay).
+
+
+	Usage Examples:
+	===============
+
+	docker pushrm login quay.io
+	docker pushrm login --provider gitlab registry.example.com
+	echo "$QUAY_APIKEY" | docker pushrm login --token-stdin quay.example.com
+
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := runLogin(args[0]); err != nil {
+			return reportExitError(cmd, err)
+		}
+		return nil
+	},
+}
+
+// logoutCmd represents the logout command
+var logoutCmd = &cobra.Command{
+	Use:   "logout SERVER",
+	Args:  cobra.ExactArgs(1),
+	Short: "remove the api key for a registry server from the Docker credentials store",
+	Long: `help for docker pushrm logout
+
+	docker pushrm logout SERVER
+
+	removes the api key that was stored with 'docker pushrm login'.
+	The login of 'docker login' for the server is not affected.
+
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := runLogout(args[0]); err != nil {
+			return reportExitError(cmd, err)
+		}
+		return nil
+	},
+}
+
+func runLogin(server string) error {
+	servername := util.ConvertToHostname(server)
+	log.Debug("subcommand \"login\" called for server ", servername)
+
+	if err := setupRetries(); err != nil {
+		return failed(err)
+	}
+	setupInsecureRegistries()
+
+	prov, providername, err := getProvider(loginProvider, provider.Target{Servername: servername})
+	if err != nil {
+		return failed(err)
+	}
+	validator, ok := prov.(provider.TokenValidator)
+	if !ok {
+		return failed(usageError{errors.New("provider " + providername + " uses the Docker login, run \"docker login " + servername + "\" instead")})
+	}
+
+	token, err := readToken(servername)
+	if err != nil {
+		return failed(err)
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+	owner, err := validator.ValidateToken(ctx, servername, token)
+	if err != nil {
+		log.Debug(err)
+		return failed(fmt.Errorf("api key for %s (provider %s) was not accepted: %w", servername, providername, err))
+	}
+	if owner == "" {
+		owner = "apikey"
+	}
+	log.Debug("api key for ", servername, " belongs to ", owner)
+
+	if err := util.StoreApikey(servername, owner, token); err != nil {
+		return failed(err)
+	}
+
+	fmt.Println("Login Succeeded")
+	return nil
+}
+
+// readToken reads the api key from stdin ("--token-stdin") or prompts for it (without echo)
+func readToken(servername string) (string, error) {
+	var token string
+	if tokenStdin {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			log.Debug(err)
+			return "", errors.New("could not read api key from stdin")
+		}
+		token = strings.TrimSpace(string(data))
+	} else {
+		fd := int(os.Stdin.Fd())
+		if !term.IsTerminal(fd) {
+			return "", usageError{errors.New("cannot prompt for the api key without a terminal, use \"--token-stdin\"")}
+		}
+		fmt.Fprint(os.Stderr, "API key for "+servername+": ")
+		data, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			log.Debug(err)
+			return "", errors.New("could not read api key from terminal")
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	if token == "" {
+		return "", usageError{errors.New("api key is empty")}
+	}
+	return token, nil
+}
+
+func runLogout(server string) error {
+	servername := util.ConvertToHostname(server)
+	log.Debug("subcommand \"logout\" called for server ", servername)
+
+	if err := util.EraseApikey(servername); err != nil {
+		return failed(err)
+	}
+
+	fmt.Println("Removing api key for " + servername)
+	return nil
+}
+
+func init() {
+	pushrmCmd.AddCommand(loginCmd)
+	pushrmCmd.AddCommand(logoutCmd)
+
+	loginCmd.Flags().StringVarP(&loginProvider, "provider", "p", "quay", "repo type: quay, gitlab")
+	loginCmd.Flags().BoolVar(&tokenStdin, "token-stdin", false, "read the api key from stdin")
+}
@@ -0,0 +1,156 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"unicode/utf8"
+
+	"github.com/christian-korneck/docker-pushrm/util"
+	"github.com/mitchellh/mapstructure"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// manifestEntry is an image in the project manifest. Example:
+//
+//  images:
+//    - name: web
+//      file: images/web/README-containers.md
+//      short: Web frontend
+//      provider: harbor2
+//      targets:
+//        - docker.io/acme/web
+//        - harbor.local/acme/web
+//        - name: quay.example.com/acme/web
+//          provider: quay
+type manifestEntry struct {
+	Name     string
+	File     string
+	Short    string
+	Provider string
+	Targets  []manifestTarget
+}
+
+// manifestTarget is a target of a manifest entry, with an optional provider
+type manifestTarget struct {
+	Name     string
+	Provider string
+}
+
+// targetFromString allows targets to be plain strings in the manifest
+func targetFromString(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() == reflect.String && to == reflect.TypeOf(manifestTarget{}) {
+		return map[string]interface{}{"name": data}, nil
+	}
+	return data, nil
+}
+
+// getManifestJobs reads the project manifest and returns a job for each target of each entry (only entries listed in only, if not empty)
+func getManifestJobs(manifestFile string, only []string, defaultProvider string, defaultFile string, defaultShort string) (jobs []pushJob, err error) {
+	usedFile, err := initManifest(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	// paths in the manifest are relative to the manifest file
+	basedir := filepath.Dir(usedFile)
+
+	var entries []manifestEntry
+	hook := viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(targetFromString))
+	if err := manifest.UnmarshalKey("images", &entries, hook); err != nil {
+		log.Debug(err)
+		return nil, usageError{errors.New("error parsing manifest file " + usedFile + ". Expected a list of images under the key \"images\". ")}
+	}
+	if len(entries) == 0 {
+		return nil, usageError{errors.New("manifest file " + usedFile + " has no images")}
+	}
+
+	for _, name := range only {
+		found := false
+		for _, entry := range entries {
+			if entry.Name == name {
+				found = true
+			}
+		}
+		if !found {
+			return nil, usageError{errors.New("image \"" + name + "\" not found in manifest file " + usedFile)}
+		}
+	}
+
+	for i, entry := range entries {
+		if entry.Name == "" {
+			entry.Name = fmt.Sprint("#", i+1)
+		}
+		if len(only) > 0 && !util.StringInSlice(entry.Name, only) {
+			log.Debug("skipping manifest entry ", entry.Name)
+			continue
+		}
+
+		if len(entry.Targets) == 0 {
+			return nil, usageError{errors.New("image " + entry.Name + " in manifest file " + usedFile + " has no targets")}
+		}
+
+		if entry.Short == "" {
+			entry.Short = defaultShort
+		}
+		if utf8.RuneCountInString(entry.Short) > 100 {
+			return nil, usageError{errors.New("Short description of image " + entry.Name + " is too long (max 100 characters)")}
+		}
+
+		file := defaultFile
+		if entry.File != "" {
+			file = entry.File
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(basedir, file)
+			}
+		}
+		if file == "" {
+			file, err = util.FindReadmeFileIn(basedir)
+			if err != nil {
+				return nil, err
+			}
+		}
+		log.Debug("manifest entry ", entry.Name, ": using README file: ", file)
+
+		readme, err := util.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, target := range entry.Targets {
+			providername := defaultProvider
+			if entry.Provider != "" {
+				providername = entry.Provider
+			}
+			if target.Provider != "" {
+				providername = target.Provider
+			}
+			jobs = append(jobs, pushJob{targetinfo: target.Name, providername: providername, file: file, readme: readme, shortdesc: entry.Short})
+		}
+	}
+
+	return jobs, nil
+}
@@ -0,0 +1,196 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/christian-korneck/docker-pushrm/provider/provider"
+)
+
+// exit codes (documented in the help text of pushrm)
+const (
+	exitCodeError      = 1   // generic error
+	exitCodeDiffers    = 2   // dry-run: the remote content differs from the local content
+	exitCodeUsage      = 3   // invalid commandline (flags, arguments, target names)
+	exitCodeAuth       = 4   // credentials missing, rejected or without permission
+	exitCodeNotFound   = 5   // repo not found
+	exitCodeValidation = 6   // content rejected by the repo server or stored differently than pushed
+	exitCodeNetwork    = 7   // repo server not reachable, unavailable or rate limited (transient, a retry might help)
+	exitCodeTimeout    = 8   // "--timeout" or "--request-timeout" exceeded
+	exitCodeCanceled   = 130 // Ctrl-C (128 + SIGINT, like shells)
+)
+
+// error categories (json output)
+const (
+	categoryError      = "error"
+	categoryUsage      = "usage"
+	categoryAuth       = "auth"
+	categoryPermission = "permission"
+	categoryNotFound   = "notfound"
+	categoryValidation = "validation"
+	categoryNetwork    = "network"
+	categoryTimeout    = "timeout"
+	categoryCanceled   = "canceled"
+)
+
+// output formats ("--output")
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// usageError is an error in the commandline (exit code 3)
+type usageError struct {
+	err error
+}
+
+func (e usageError) Error() string {
+	return e.err.Error()
+}
+
+func (e usageError) Unwrap() error {
+	return e.err
+}
+
+// errorCategory classifies an error
+func errorCategory(err error) string {
+	var usage usageError
+	if errors.As(err, &usage) {
+		return categoryUsage
+	}
+	switch provider.KindOf(err) {
+	case provider.ErrorAuth:
+		return categoryAuth
+	case provider.ErrorPermission:
+		return categoryPermission
+	case provider.ErrorNotFound:
+		return categoryNotFound
+	case provider.ErrorValidation:
+		return categoryValidation
+	case provider.ErrorNetwork:
+		return categoryNetwork
+	case provider.ErrorTimeout:
+		return categoryTimeout
+	case provider.ErrorCanceled:
+		return categoryCanceled
+	default:
+		return categoryError
+	}
+}
+
+// exitCode returns the exit code for an error category
+func exitCode(category string) int {
+	switch category {
+	case categoryUsage:
+		return exitCodeUsage
+	case categoryAuth, categoryPermission:
+		return exitCodeAuth
+	case categoryNotFound:
+		return exitCodeNotFound
+	case categoryValidation:
+		return exitCodeValidation
+	case categoryNetwork:
+		return exitCodeNetwork
+	case categoryTimeout:
+		return exitCodeTimeout
+	case categoryCanceled:
+		return exitCodeCanceled
+	default:
+		return exitCodeError
+	}
+}
+
+// failed returns an exitError for err with the exit code of its category
+func failed(err error) exitError {
+	return exitError{code: exitCode(errorCategory(err)), err: err}
+}
+
+// jsonResult is the outcome of a target for "--output json"
+type jsonResult struct {
+	Target        string `json:"target"`
+	Provider      string `json:"provider"`
+	Action        string `json:"action"` // updated, unchanged, failed (dry-run: differs, unchanged, failed)
+	BytesPushed   int    `json:"bytes_pushed"`
+	URL           string `json:"url,omitempty"`
+	DurationMs    int64  `json:"duration_ms"`
+	Error         string `json:"error,omitempty"`
+	ErrorCategory string `json:"error_category,omitempty"`
+	Diff          string `json:"diff,omitempty"` // dry-run only
+}
+
+// printJSON prints the outcome of all targets as json array
+func printJSON(results []pushResult, dryrun bool) {
+	out := make([]jsonResult, 0, len(results))
+	for _, result := range results {
+		r := jsonResult{
+			Target:      result.repo,
+			Provider:    result.providername,
+			Action:      string(result.action),
+			BytesPushed: result.bytes,
+			URL:         result.url,
+			DurationMs:  result.duration.Milliseconds(),
+			Diff:        result.diff,
+		}
+		switch {
+		case result.err != nil:
+			r.Action = "failed"
+			r.Error = result.err.Error()
+			r.ErrorCategory = errorCategory(result.err)
+		case dryrun && result.diff != "":
+			r.Action = "differs"
+		case dryrun:
+			r.Action = string(provider.ActionUnchanged)
+		}
+		out = append(out, r)
+	}
+
+	data, _ := json.MarshalIndent(out, "", "  ")
+	fmt.Println(string(data))
+}
+
+// resultsExitCode returns the exit code for the outcome of all targets: the code of the error category if all failed
+// targets failed for the same kind of reason, otherwise 1. Without failures it's 2 if a dry-run found differences.
+func resultsExitCode(results []pushResult, dryrun bool) int {
+	code := 0
+	differs := false
+	for _, result := range results {
+		if result.err != nil {
+			c := exitCode(errorCategory(result.err))
+			if code != 0 && c != code {
+				c = exitCodeError
+			}
+			code = c
+			continue
+		}
+		if dryrun && result.diff != "" {
+			differs = true
+		}
+	}
+	if code == 0 && differs {
+		return exitCodeDiffers
+	}
+	return code
+}
@@ -0,0 +1,151 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var pullprovider string
+var pullfile string
+var pullrmForce bool
+
+// pullrmCmd represents the pullrm command
+var pullrmCmd = &cobra.Command{
+	Use:   "pullrm NAME[:TAG]",
+	Args:  cobra.MaximumNArgs(1),
+	Short: "pull README from container registry (Dockerhub, quay, harbor2, gitlab) into a local file",
+	Long: `help for docker pullrm
+
+	docker pullrm NAME[:TAG] [flags]
+
+	fetches the current repo description from the container
+	registry (Dockerhub, quay, harbor2, gitlab) and writes it to the
+	file README-containers.md in the current working directory
+	(or to the path given with '--file <path>').
+
+	This is useful to onboard an existing repo: the README that
+	was edited in the registry's webinterface becomes a local
+	file that can be pushed with 'docker pushrm' from then on.
+
+	An existing file is not overwritten unless '--force' is set.
+
+	Target names, providers and logins work the same way as for
+	'docker pushrm' (see 'docker pushrm --help').
+
+
+	Usage Examples:
+	===============
+
+	docker pullrm my-account/hello-world
+	docker pullrm --file docs/README.md quay.io/my-organization/hello-world
+	docker pullrm --provider harbor2 my-harbor-server.com/my-project/hello-world
+
+`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		// flags are bound here (and not in init) because subcommands share the same config keys
+		viper.BindPFlag("provider", cmd.Flags().Lookup("provider"))
+		viper.BindPFlag("file", cmd.Flags().Lookup("file"))
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := runPull(args); err != nil {
+			return reportExitError(cmd, err)
+		}
+		return nil
+	},
+}
+
+func runPull(args []string) error {
+	pullrmProvider := viper.GetString("provider")
+	pullrmFile := viper.GetString("file")
+
+	log.Debug("subcommand \"pullrm\" called")
+
+	if err := setupRetries(); err != nil {
+		return failed(err)
+	}
+	setupInsecureRegistries()
+
+	targetinfo, err := getTargetinfo(args)
+	if err != nil {
+		return failed(usageError{err})
+	}
+
+	target, err := parseTarget(targetinfo)
+	if err != nil {
+		return failed(err)
+	}
+
+	if pullrmFile == "" {
+		pullrmFile = "README-containers.md"
+	}
+	log.Debug("using README file: " + pullrmFile)
+
+	if _, err := os.Stat(pullrmFile); err == nil && !pullrmForce {
+		return exitError{code: 1, err: errors.New("file " + pullrmFile + " already exists. Use \"--force\" to overwrite it. ")}
+	}
+
+	prov, pullrmProvider, err := getProvider(pullrmProvider, target)
+	if err != nil {
+		return failed(err)
+	}
+
+	creds, err := getCredentials(prov, target)
+	if err != nil {
+		return failed(err)
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+	content, err := prov.Pullrm(ctx, target, creds)
+	if err != nil {
+		return failed(err)
+	}
+
+	if content.Shortdesc != "" {
+		log.Debug("remote short description (not written to file): ", content.Shortdesc)
+	}
+
+	if err := ioutil.WriteFile(pullrmFile, []byte(content.Readme), 0644); err != nil {
+		log.Debug(err)
+		return exitError{code: 1, err: errors.New("could not write README file: " + pullrmFile)}
+	}
+
+	log.Debug("README of repo ", targetinfo, " (provider ", pullrmProvider, ") written to ", pullrmFile)
+
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(pullrmCmd)
+
+	pullrmCmd.Flags().StringVarP(&pullprovider, "provider", "p", "dockerhub", "repo type: dockerhub, harbor2, quay, gitlab")
+	pullrmCmd.Flags().StringVarP(&pullfile, "file", "f", "", "file to write the README to (default \"./README-containers.md\")")
+	pullrmCmd.Flags().BoolVar(&pullrmForce, "force", false, "overwrite an existing file")
+}
@@ -24,6 +24,8 @@ package main
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/christian-korneck/docker-pushrm/cmd"
 	"github.com/christian-korneck/docker-pushrm/util"
@@ -31,13 +33,22 @@ import (
 
 func main() {
 
-	// if called as a standalone tool (not as a docker cli plugin), proxy directly to the `pushrm` subcommand
-	if (os.Getenv("DOCKER_CLI_PLUGIN_ORIGINAL_CLI_COMMAND") == "") && (util.StringInSlice("docker-cli-plugin-metadata", os.Args) == false) {
+	// the same executable can be installed as `docker-pushrm` and `docker-pullrm`
+	subcommand := "pushrm"
+	if strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe") == "docker-pullrm" {
+		subcommand = "pullrm"
+	}
+
+	// `docker-pushrm pullrm ...` also works when called as a standalone tool
+	explicitPullrm := len(os.Args) > 1 && os.Args[1] == "pullrm"
+
+	// if called as a standalone tool (not as a docker cli plugin), proxy directly to the `pushrm` (or `pullrm`) subcommand
+	if (os.Getenv("DOCKER_CLI_PLUGIN_ORIGINAL_CLI_COMMAND") == "") && (util.StringInSlice("docker-cli-plugin-metadata", os.Args) == false) && !explicitPullrm {
 
 		newArgs := make([]string, (len(os.Args) + 1))
 
 		newArgs[0] = os.Args[0]
-		newArgs[1] = "pushrm"
+		newArgs[1] = subcommand
 		for i := 2; i <= len(os.Args); i++ {
 			newArgs[i] = os.Args[i-1]
 		}
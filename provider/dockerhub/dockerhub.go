@@ -23,12 +23,17 @@ THE SOFTWARE.
 package dockerhub
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"strings"
+	"sync"
 
+	"github.com/christian-korneck/docker-pushrm/provider/provider"
 	"github.com/christian-korneck/docker-pushrm/util"
 	log "github.com/sirupsen/logrus"
 )
@@ -38,21 +43,47 @@ type Dockerhub struct {
 }
 
 //Pushrm is the main provider function
-func (f Dockerhub) Pushrm(servername string, namespacename string, reponame string, tagname string, dockerUser string, dockerPasswd string, readme string, shortdesc string) error {
+func (f Dockerhub) Pushrm(ctx context.Context, target provider.Target, creds provider.Credentials, content provider.Content) (provider.Result, error) {
 
 	log.Debug("Dockerhub.Pushrm called")
-	jwt, err := GetJwt(dockerUser, dockerPasswd)
+	auth, err := GetAuthorization(ctx, creds)
+	if err != nil {
+		return provider.Result{}, err
+	}
+
+	// skip the write if the repo server already has the same content
+	remoteReadme, remoteShortdesc, err := GetDescription(ctx, auth, target.Namespacename(), target.Reponame())
+	if err != nil {
+		log.Debug("could not fetch current repo description, pushing anyway: ", err)
+	} else if content.Matches(provider.Content{Readme: remoteReadme, Shortdesc: remoteShortdesc}) {
+		log.Debug("remote content matches, skipping push")
+		return provider.Result{Action: provider.ActionUnchanged, URL: webURL(target)}, nil
+	}
+
+	err = PatchDescription(ctx, auth, content.Readme, target.Namespacename(), target.Reponame(), content.Shortdesc)
 	if err != nil {
 		log.Debug(err)
-		return fmt.Errorf("error trying to get a JWT token from Dockerhub for the stored Docker login. Try \"docker logout\" and \"docker login\". Also, if you have 2FA auth enabled in Dockerhub you'll need to disable it for this tool to work. (This is an unfortunate Dockerhub limitation, see docs for more infos). ")
+		return provider.Result{}, fmt.Errorf("error pushing readme to repo server. See error message below. Run with \"--debug\" for more details. \n\n%w", err)
+	}
+
+	return provider.Result{Action: provider.ActionUpdated, URL: webURL(target)}, nil
+}
+
+//Pullrm reads the current repo description
+func (f Dockerhub) Pullrm(ctx context.Context, target provider.Target, creds provider.Credentials) (provider.Content, error) {
+
+	log.Debug("Dockerhub.Pullrm called")
+	auth, err := GetAuthorization(ctx, creds)
+	if err != nil {
+		return provider.Content{}, err
 	}
-	err = PatchDescription(jwt, readme, namespacename, reponame, shortdesc)
+	readme, shortdesc, err := GetDescription(ctx, auth, target.Namespacename(), target.Reponame())
 	if err != nil {
 		log.Debug(err)
-		return fmt.Errorf("error pushing readme to repo server. See error message below. Run with \"--debug\" for more details. \n\n" + err.Error())
+		return provider.Content{}, fmt.Errorf("error fetching readme from repo server. See error message below. Run with \"--debug\" for more details. \n\n%w", err)
 	}
 
-	return nil
+	return provider.Content{Readme: readme, Shortdesc: shortdesc}, nil
 }
 
 //GetAuthident returns authident for local Docker credentials store
@@ -62,68 +93,282 @@ func (f Dockerhub) GetAuthident() (authident string) {
 	return
 }
 
-//GetJwt Auth against Dockerhub with user/passwd and request a jwt token
-func GetJwt(dockerUser string, dockerPasswd string) (jwt string, error error) {
+//Capabilities returns the features supported by Dockerhub
+func (f Dockerhub) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		ShortDescription: true,
+		TagReadme:        false,
+		ReadBack:         true,
+		NestedPaths:      false,
+		MaxReadmeSize:    25000,
+	}
+}
+
+// hubURL returns the base url of the Dockerhub api (can be overridden with env var PUSHRM_DOCKERHUB_URL)
+func hubURL() string {
+	return util.APIBaseURL("dockerhub", "https://hub.docker.com")
+}
+
+// webURL returns the url of the repo page on Dockerhub
+func webURL(target provider.Target) string {
+	return "https://hub.docker.com/r/" + target.Repository()
+}
+
+// prefix of Dockerhub organization access tokens (OAT). They can't be used with the login endpoint.
+const orgAccessTokenPrefix = "dckr_oat_"
+
+// auth tokens per login, so that concurrent pushes don't log in (and prompt for a 2FA code) several times,
+// and the logins that are in flight
+var tokenCache = struct {
+	sync.Mutex
+	tokens   map[string]string
+	inflight map[string]*hubLogin
+}{tokens: make(map[string]string), inflight: make(map[string]*hubLogin)}
+
+// hubLogin is a login in flight. Concurrent callers with the same credentials wait for its result
+type hubLogin struct {
+	done chan struct{}
+	auth string
+	err  error
+}
+
+// only one 2FA prompt at a time, concurrent logins of different accounts would mix up the terminal
+var promptLock sync.Mutex
+
+//GetAuthorization returns the value of the Authorization header for api calls with a Docker login
+//(password, Personal Access Token, organization access token or a registry token)
+func GetAuthorization(ctx context.Context, creds provider.Credentials) (auth string, error error) {
+	// SSO logins are out of scope for Dockerhub: the identity token can only be exchanged for a token of the registry
+	// (registry.docker.io), that token isn't accepted by the Dockerhub api
+	if creds.Type() == provider.CredentialIdentityToken {
+		return "", provider.Errorf(provider.ErrorAuth, "SSO logins (identity tokens) aren't supported for Dockerhub, the Dockerhub api doesn't accept them. Create a Personal Access Token (PAT) and log in with \"docker login -u <username>\" and the PAT as password (or set env vars DOCKER_USER and DOCKER_PASS)")
+	}
+	if creds.Type() != provider.CredentialBasic {
+		log.Debug("using Dockerhub ", creds.Type(), " login")
+		return creds.Authorization(), nil
+	}
+	key := hubURL() + "\x00" + creds.DockerUser + "\x00" + creds.DockerPasswd
+
+	// the lock is only held to access the cache, not during the login
+	tokenCache.Lock()
+	if auth, ok := tokenCache.tokens[key]; ok {
+		tokenCache.Unlock()
+		return auth, nil
+	}
+	if login, ok := tokenCache.inflight[key]; ok {
+		tokenCache.Unlock()
+		select {
+		case <-login.done:
+			return login.auth, login.err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	login := &hubLogin{done: make(chan struct{})}
+	tokenCache.inflight[key] = login
+	tokenCache.Unlock()
+
+	login.auth, login.err = hubAuthorization(ctx, creds)
+
+	tokenCache.Lock()
+	delete(tokenCache.inflight, key)
+	if login.err == nil {
+		tokenCache.tokens[key] = login.auth
+	}
+	tokenCache.Unlock()
+	close(login.done)
+
+	return login.auth, login.err
+}
+
+// hubAuthorization logs in with a username and password, Personal Access Token or organization access token
+func hubAuthorization(ctx context.Context, creds provider.Credentials) (auth string, error error) {
+	if strings.HasPrefix(creds.DockerPasswd, orgAccessTokenPrefix) {
+		log.Debug("using Dockerhub organization access token for ", creds.DockerUser)
+		token, err := GetAccessToken(ctx, creds.DockerUser, creds.DockerPasswd)
+		if err != nil {
+			return "", err
+		}
+		auth = "Bearer " + token
+	} else {
+		jwt, err := GetJwt(ctx, creds.DockerUser, creds.DockerPasswd)
+		if err != nil {
+			return "", err
+		}
+		auth = "JWT " + jwt
+	}
+	return auth, nil
+}
 
-	url := "https://hub.docker.com/v2/users/login/"
-	method := "POST"
+//GetJwt Auth against Dockerhub with user/passwd (or Personal Access Token) and request a jwt token.
+//Accounts with 2FA enabled need a TOTP code (env var DOCKER_TOTP or interactive prompt) if a password is used.
+func GetJwt(ctx context.Context, dockerUser string, dockerPasswd string) (jwt string, error error) {
 
-	payloadJSON, err := json.Marshal(map[string]string{"username": dockerUser, "password": dockerPasswd})
+	status, dat, err := postJSON(ctx, hubURL()+"/v2/users/login/", map[string]string{"username": dockerUser, "password": dockerPasswd})
 	if err != nil {
-		log.Debug(err)
-		return "", fmt.Errorf("error retrieving Dockerhub jwt token, error marshal payload")
+		return "", fmt.Errorf("error retrieving Dockerhub jwt token, %w", err)
+	}
+
+	log.Debug("retrieve Dockerhub jwt token, status code: ", status)
+
+	if status == 401 {
+		// accounts with 2FA get a token for the second step instead of an error
+		if twoFactorToken, ok := dat["login_2fa_token"].(string); ok && twoFactorToken != "" {
+			log.Debug("Dockerhub account ", dockerUser, " requires 2FA")
+			return get2FAJwt(ctx, dockerUser, twoFactorToken)
+		}
+		return "", provider.Errorf(provider.ErrorAuth, "Dockerhub rejected the credentials for user %s (wrong username, password or access token%s). Try \"docker logout\" and \"docker login\". ", dockerUser, serverDetail(dat))
 	}
 
-	payloadStr := util.BytesToString(payloadJSON)
-	payload := strings.NewReader(payloadStr)
+	if status != 200 {
+		return "", fmt.Errorf("error retrieving Dockerhub jwt token, bad status code for response: %d%s", status, serverDetail(dat))
+	}
 
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, payload)
+	if token, ok := dat["token"].(string); ok && token != "" {
+		return token, nil
+	}
+	return "", fmt.Errorf("error retrieving Dockerhub jwt token, no jtw token received")
+}
 
+// get2FAJwt completes the 2FA login with a TOTP code
+func get2FAJwt(ctx context.Context, dockerUser string, twoFactorToken string) (jwt string, error error) {
+	code, err := getTOTPCode(dockerUser)
 	if err != nil {
-		log.Debug(err)
-		return "", fmt.Errorf("error retrieving Dockerhub jwt token, error creating http request")
+		return "", err
 	}
-	req.Header.Add("Content-Type", "application/json")
 
-	res, err := client.Do(req)
+	status, dat, err := postJSON(ctx, hubURL()+"/v2/users/2fa-login/", map[string]string{"login_2fa_token": twoFactorToken, "code": code})
 	if err != nil {
+		return "", fmt.Errorf("error retrieving Dockerhub jwt token (2FA), %w", err)
+	}
+
+	log.Debug("retrieve Dockerhub jwt token (2FA), status code: ", status)
+
+	if status == 401 {
+		return "", provider.Errorf(provider.ErrorAuth, "Dockerhub rejected the 2FA code for user %s%s. Codes are only valid for a short time, try again with a fresh one. ", dockerUser, serverDetail(dat))
+	}
+	if status != 200 {
+		return "", fmt.Errorf("error retrieving Dockerhub jwt token (2FA), bad status code for response: %d%s", status, serverDetail(dat))
+	}
+
+	if token, ok := dat["token"].(string); ok && token != "" {
+		return token, nil
+	}
+	return "", fmt.Errorf("error retrieving Dockerhub jwt token (2FA), no jtw token received")
+}
+
+// getTOTPCode returns the 2FA code from env var DOCKER_TOTP or asks for it (if running in a terminal)
+func getTOTPCode(dockerUser string) (string, error) {
+	if code := os.Getenv("DOCKER_TOTP"); code != "" {
+		log.Debug("using 2FA code from env var DOCKER_TOTP")
+		return code, nil
+	}
+
+	noCodeErr := fmt.Errorf("Dockerhub account %s has 2FA enabled. Set env var DOCKER_TOTP to a current code from your authenticator app or (recommended for CI) log in with a Personal Access Token instead of the password. ", dockerUser)
+
+	fi, err := os.Stdin.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return "", noCodeErr
+	}
+
+	promptLock.Lock()
+	defer promptLock.Unlock()
+
+	fmt.Fprint(os.Stderr, "Dockerhub 2FA code for "+dockerUser+": ")
+	code, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	code = strings.TrimSpace(code)
+	if code == "" {
 		log.Debug(err)
-		return "", fmt.Errorf("error retrieving Dockerhub jwt token, error making http request")
+		return "", noCodeErr
 	}
+	return code, nil
+}
 
-	log.Debug("retrieve Dockerhub jwt token, status code: ", res.StatusCode)
+//GetAccessToken Auth against Dockerhub with an organization access token and request an access token
+func GetAccessToken(ctx context.Context, identifier string, secret string) (token string, error error) {
 
-	if res.StatusCode != 200 {
-		return "", fmt.Errorf("error retrieving Dockerhub jwt token, bad status code for response: " + res.Status)
+	status, dat, err := postJSON(ctx, hubURL()+"/v2/auth/token", map[string]string{"identifier": identifier, "secret": secret})
+	if err != nil {
+		return "", fmt.Errorf("error retrieving Dockerhub access token, %w", err)
+	}
+
+	log.Debug("retrieve Dockerhub access token, status code: ", status)
 
+	if status == 401 || status == 403 {
+		return "", provider.Errorf(provider.ErrorAuth, "Dockerhub rejected the organization access token for %s (wrong organization name, or the token is expired or revoked%s). ", identifier, serverDetail(dat))
+	}
+	if status != 200 {
+		return "", fmt.Errorf("error retrieving Dockerhub access token, bad status code for response: %d%s", status, serverDetail(dat))
+	}
+
+	if token, ok := dat["access_token"].(string); ok && token != "" {
+		return token, nil
+	}
+	return "", fmt.Errorf("error retrieving Dockerhub access token, no token received")
+}
+
+// postJSON sends a json payload to a Dockerhub auth endpoint and returns the status code and the parsed json response
+func postJSON(ctx context.Context, apiurl string, payload map[string]string) (status int, dat map[string]interface{}, error error) {
+
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		log.Debug(err)
+		return 0, nil, fmt.Errorf("error marshal payload")
+	}
+
+	client, err := util.NewHTTPClient("hub.docker.com")
+	if err != nil {
+		log.Debug(err)
+		return 0, nil, fmt.Errorf("error creating http client: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", apiurl, strings.NewReader(util.BytesToString(payloadJSON)))
+	if err != nil {
+		log.Debug(err)
+		return 0, nil, fmt.Errorf("error creating http request")
+	}
+	req.Header.Add("Content-Type", "application/json")
+	// logins don't have side effects
+	util.MarkIdempotent(req)
+
+	res, err := client.Do(req)
+	if err != nil {
+		log.Debug(err)
+		return 0, nil, provider.RequestError(err, "error making http request")
 	}
 
 	defer res.Body.Close()
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		log.Debug(err)
-		return "", fmt.Errorf("error retrieving Dockerhub jwt token, error reading response body")
+		return res.StatusCode, nil, provider.RequestError(err, "error reading response body")
 	}
 
-	var dat map[string]interface{}
 	if err := json.Unmarshal(body, &dat); err != nil {
 		log.Debug(err)
-		return "", fmt.Errorf("error retrieving Dockerhub jwt token, error parsing json")
+		if res.StatusCode == 200 {
+			return res.StatusCode, nil, fmt.Errorf("error parsing json")
+		}
 	}
 
-	if dat["token"] == nil || dat["token"].(string) == "" {
-		return "", fmt.Errorf("error retrieving Dockerhub jwt token, no jtw token received")
-	}
+	return res.StatusCode, dat, nil
+}
 
-	return dat["token"].(string), nil
+// serverDetail returns the error detail of a Dockerhub response (formatted for appending to an error message)
+func serverDetail(dat map[string]interface{}) string {
+	if detail, ok := dat["detail"].(string); ok && detail != "" {
+		return ". Server responded: \"" + detail + "\""
+	}
+	if message, ok := dat["message"].(string); ok && message != "" {
+		return ". Server responded: \"" + message + "\""
+	}
+	return ""
 }
 
 //PatchDescription - api call to update the repo description
-func PatchDescription(jwt string, readme string, namespacename string, reponame string, shortdesc string) (error error) {
+func PatchDescription(ctx context.Context, auth string, readme string, namespacename string, reponame string, shortdesc string) (error error) {
 
 	// trailing slash is crucial
-	apiurl := "https://hub.docker.com/v2/repositories/" + namespacename + "/" + reponame + "/"
+	apiurl := hubURL() + "/v2/repositories/" + namespacename + "/" + reponame + "/"
 	method := "PATCH"
 
 	bodydata := make(map[string]string)
@@ -134,27 +379,33 @@ func PatchDescription(jwt string, readme string, namespacename string, reponame
 	jsonbody, _ := json.Marshal(bodydata)
 
 	payload := strings.NewReader(string(jsonbody))
-	client := &http.Client{}
-	req, err := http.NewRequest(method, apiurl, payload)
+	client, err := util.NewHTTPClient("hub.docker.com")
+	if err != nil {
+		log.Debug(err)
+		return fmt.Errorf("error pushing README, error creating http client: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, apiurl, payload)
 	if err != nil {
 		log.Debug(err)
 		return fmt.Errorf("error pushing README, error creating http request")
 	}
 
-	req.Header.Add("Authorization", "JWT "+jwt)
+	req.Header.Add("Authorization", auth)
 	req.Header.Add("Content-Type", "application/json")
+	// the description gets set to absolute values, sending it twice doesn't change the result
+	util.MarkIdempotent(req)
 
 	res, err := client.Do(req)
 	if err != nil {
 		log.Debug(err)
-		return fmt.Errorf("error pushing README, error creating http request")
+		return provider.RequestError(err, "error pushing README, error creating http request")
 	}
 
 	defer res.Body.Close()
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		log.Debug(err)
-		return fmt.Errorf("error pushing README, error reading response body")
+		return provider.RequestError(err, "error pushing README, error reading response body")
 	}
 
 	log.Debug("push readme, response body: ", string(body))
@@ -162,33 +413,95 @@ func PatchDescription(jwt string, readme string, namespacename string, reponame
 	var dat map[string]interface{}
 	if err := json.Unmarshal(body, &dat); err != nil {
 		log.Debug(err)
-		return fmt.Errorf("error pushing README, error parsing returned json")
+		// error responses of proxies aren't json
+		if res.StatusCode == 200 {
+			return fmt.Errorf("error pushing README, error parsing returned json")
+		}
 	}
 
 	log.Debug("push README, status code: ", res.StatusCode)
 
 	if res.StatusCode != 200 {
 		msg := "error pushing README, bad status code for response: " + res.Status
-		if dat["detail"] != nil {
-			msg = msg + ". Server responded: \"" + dat["detail"].(string) + "\""
+		if detail, ok := dat["detail"].(string); ok {
+			msg = msg + ". Server responded: \"" + detail + "\""
 		}
-		if res.StatusCode == 403 {
-			msg = msg + ". Try \"docker logout\" and \"docker login\". If you use a PAT token make sure it has sufficient privileges (\"admin\" scope)."
-
+		switch res.StatusCode {
+		case 401:
+			msg = msg + ". The credentials were rejected. Try \"docker logout\" and \"docker login\"."
+		case 403:
+			msg = msg + ". The credentials are valid, but lack the permission to edit this repo. A Personal Access Token (PAT) needs the \"Read, Write, Delete\" (admin) scope, an organization access token needs write access to the repo, and the user needs to be admin of the repo."
 		}
-		return fmt.Errorf(msg)
+		return provider.StatusError(res.StatusCode, msg)
 
 	}
 
 	if dat["full_description"] != readme {
-		return fmt.Errorf("error pushing README, pushed readme to repo server but validation failed")
+		return provider.Errorf(provider.ErrorValidation, "error pushing README, pushed readme to repo server but validation failed")
 	}
 
 	if shortdesc != "" && dat["description"] != shortdesc {
-		return fmt.Errorf("error setting Short Description, pushed to repo server but validation failed")
+		return provider.Errorf(provider.ErrorValidation, "error setting Short Description, pushed to repo server but validation failed")
 	}
 
 	log.Debug("content validation successfull, readme successfully pushed to repo server")
 	return nil
 
 }
+
+//GetDescription - api call to read the repo description
+func GetDescription(ctx context.Context, auth string, namespacename string, reponame string) (readme string, shortdesc string, error error) {
+
+	// trailing slash is crucial
+	apiurl := hubURL() + "/v2/repositories/" + namespacename + "/" + reponame + "/"
+	method := "GET"
+
+	client, err := util.NewHTTPClient("hub.docker.com")
+	if err != nil {
+		log.Debug(err)
+		return "", "", fmt.Errorf("error fetching README, error creating http client: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, apiurl, nil)
+	if err != nil {
+		log.Debug(err)
+		return "", "", fmt.Errorf("error fetching README, error creating http request")
+	}
+
+	req.Header.Add("Authorization", auth)
+
+	res, err := client.Do(req)
+	if err != nil {
+		log.Debug(err)
+		return "", "", provider.RequestError(err, "error fetching README, error making http request")
+	}
+
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		log.Debug(err)
+		return "", "", provider.RequestError(err, "error fetching README, error reading response body")
+	}
+
+	var dat map[string]interface{}
+	if err := json.Unmarshal(body, &dat); err != nil {
+		log.Debug(err)
+		if res.StatusCode == 200 {
+			return "", "", fmt.Errorf("error fetching README, error parsing returned json")
+		}
+	}
+
+	log.Debug("fetch README, status code: ", res.StatusCode)
+
+	if res.StatusCode != 200 {
+		msg := "error fetching README, bad status code for response: " + res.Status
+		if detail, ok := dat["detail"].(string); ok {
+			msg = msg + ". Server responded: \"" + detail + "\""
+		}
+		return "", "", provider.StatusError(res.StatusCode, msg)
+	}
+
+	readme, _ = dat["full_description"].(string)
+	shortdesc, _ = dat["description"].(string)
+
+	return readme, shortdesc, nil
+}
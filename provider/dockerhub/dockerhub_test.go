@@ -0,0 +1,134 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package dockerhub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/christian-korneck/docker-pushrm/provider/provider"
+	"github.com/christian-korneck/docker-pushrm/provider/providertest"
+	"github.com/spf13/viper"
+)
+
+func TestConformance(t *testing.T) {
+	providertest.Run(t, providertest.Suite{
+		Provider: Dockerhub{},
+		Target:   provider.Target{Servername: "docker.io", Path: []string{"my-user", "my-repo"}},
+		Creds:    provider.Credentials{DockerUser: "my-user", DockerPasswd: "my-password"},
+		Setup: func(t *testing.T, url string) {
+			viper.Set("dockerhub-url", url)
+			t.Cleanup(func() { viper.Set("dockerhub-url", "") })
+		},
+		Classify: func(r *http.Request) providertest.Request {
+			switch {
+			case r.Method == "POST" && r.URL.Path == "/v2/users/login/":
+				return providertest.RequestAuth
+			case r.URL.Path != "/v2/repositories/my-user/my-repo/":
+				return providertest.RequestUnknown
+			case r.Method == "GET":
+				return providertest.RequestRead
+			case r.Method == "PATCH":
+				return providertest.RequestWrite
+			}
+			return providertest.RequestUnknown
+		},
+		AuthResponse: map[string]interface{}{"token": "my-jwt"},
+		RepoResponse: func(remote provider.Content) map[string]interface{} {
+			return map[string]interface{}{"name": "my-repo", "namespace": "my-user", "full_description": remote.Readme, "description": remote.Shortdesc}
+		},
+		ErrorResponse: func(statusCode int, message string) map[string]interface{} {
+			return map[string]interface{}{"detail": message}
+		},
+		DecodeWrite: func(body []byte) (provider.Content, error) {
+			var dat struct {
+				FullDescription *string `json:"full_description"`
+				Description     string  `json:"description"`
+			}
+			if err := json.Unmarshal(body, &dat); err != nil {
+				return provider.Content{}, err
+			}
+			if dat.FullDescription == nil {
+				return provider.Content{}, errors.New("field full_description is missing")
+			}
+			return provider.Content{Readme: *dat.FullDescription, Shortdesc: dat.Description}, nil
+		},
+	})
+}
+
+func TestGetAuthorizationConcurrent(t *testing.T) {
+	var slowLogins int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["username"] == "slow-user" {
+			atomic.AddInt32(&slowLogins, 1)
+			<-release
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": body["username"] + "-jwt"})
+	}))
+	defer server.Close()
+	viper.Set("dockerhub-url", server.URL)
+	defer viper.Set("dockerhub-url", "")
+
+	slow := provider.Credentials{DockerUser: "slow-user", DockerPasswd: "my-password"}
+	fast := provider.Credentials{DockerUser: "fast-user", DockerPasswd: "my-password"}
+
+	var wg sync.WaitGroup
+	auths := make([]string, 5)
+	errs := make([]error, 5)
+	for i := range auths {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			auths[i], errs[i] = GetAuthorization(context.Background(), slow)
+		}(i)
+	}
+
+	// the cache isn't locked while a login is in flight
+	time.Sleep(50 * time.Millisecond)
+	auth, err := GetAuthorization(context.Background(), fast)
+	if err != nil || auth != "JWT fast-user-jwt" {
+		t.Errorf("got %q, %v, want %q", auth, err, "JWT fast-user-jwt")
+	}
+
+	close(release)
+	wg.Wait()
+	for i := range auths {
+		if errs[i] != nil || auths[i] != "JWT slow-user-jwt" {
+			t.Errorf("caller %d: got %q, %v, want %q", i, auths[i], errs[i], "JWT slow-user-jwt")
+		}
+	}
+	// concurrent callers share one login
+	if n := atomic.LoadInt32(&slowLogins); n != 1 {
+		t.Errorf("got %d logins, want 1", n)
+	}
+}
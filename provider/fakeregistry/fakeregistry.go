@@ -0,0 +1,472 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+// Package fakeregistry is an in-process fake of the registry apis that docker-pushrm talks to
+// (Dockerhub, Quay, Harbor v2 and GitLab), for testing without real registries.
+//
+// Point the providers to it with the base url overrides (env vars PUSHRM_DOCKERHUB_URL,
+// PUSHRM_QUAY_URL, PUSHRM_HARBOR2_URL and PUSHRM_GITLAB_URL set to Registry.URL).
+package fakeregistry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Repo is the description of a repo on the fake registry
+type Repo struct {
+	Readme    string
+	Shortdesc string
+}
+
+//Registry is a fake registry server. All provider apis share the same repos, users and tokens.
+type Registry struct {
+	//URL - base url of the server (i.e. "http://127.0.0.1:12345")
+	URL string
+
+	server   *httptest.Server
+	mu       sync.Mutex
+	users    map[string]string // username -> password
+	totp     map[string]string // username -> 2FA code
+	tokens   map[string]string // api key / access token -> owner
+	issued   map[string]string // jwt issued by the Dockerhub login -> owner
+	readonly map[string]bool   // users (or token owners) without write permission
+	repos    map[string]*Repo  // repo path -> description
+	images   map[string]bool   // GitLab registry repositories below a project path
+	requests []string
+	failures []failure     // responses for the next requests, instead of handling them
+	delay    time.Duration // wait before responding
+}
+
+// failure is an error response that the server sends instead of handling a request
+type failure struct {
+	statusCode int
+	header     http.Header
+}
+
+//New starts a fake registry server. Close it when done.
+func New() *Registry {
+	r := &Registry{
+		users:    make(map[string]string),
+		totp:     make(map[string]string),
+		tokens:   make(map[string]string),
+		issued:   make(map[string]string),
+		readonly: make(map[string]bool),
+		repos:    make(map[string]*Repo),
+		images:   make(map[string]bool),
+	}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	r.URL = r.server.URL
+	return r
+}
+
+//Close shuts down the server
+func (r *Registry) Close() {
+	r.server.Close()
+}
+
+//AddUser adds a user with a password (also used for Harbor basic auth)
+func (r *Registry) AddUser(user string, passwd string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.users[user] = passwd
+}
+
+//Enable2FA requires a 2FA code for the Dockerhub login of a user
+func (r *Registry) Enable2FA(user string, code string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.totp[user] = code
+}
+
+//AddToken adds an api key / access token (Quay api key, GitLab token, Dockerhub organization access token, bearer token)
+func (r *Registry) AddToken(token string, owner string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tokens[token] = owner
+}
+
+//SetReadOnly removes the write permission of a user (or token owner)
+func (r *Registry) SetReadOnly(user string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.readonly[user] = true
+}
+
+//AddRepo creates a repo (path without servername, i.e. "my-user/my-repo")
+func (r *Registry) AddRepo(path string, repo Repo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.repos[path] = &repo
+}
+
+//AddImage adds a GitLab registry repository below a project (i.e. "my-group/my-project/my-image" for the project "my-group/my-project")
+func (r *Registry) AddImage(path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.images[path] = true
+}
+
+//Repo returns the current description of a repo
+func (r *Registry) Repo(path string) (Repo, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo, ok := r.repos[path]
+	if !ok {
+		return Repo{}, false
+	}
+	return *repo, true
+}
+
+//FailNext makes the server respond to the next count requests with an error (i.e. 502, or 429 with a Retry-After header)
+//instead of handling them. These requests don't show up in Requests().
+func (r *Registry) FailNext(count int, statusCode int, header http.Header) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := 0; i < count; i++ {
+		r.failures = append(r.failures, failure{statusCode: statusCode, header: header})
+	}
+}
+
+//SetDelay makes the server wait before responding to a request (like a hung server)
+func (r *Registry) SetDelay(delay time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.delay = delay
+}
+
+//Requests returns the requests that the server received so far ("<METHOD> <path>")
+func (r *Registry) Requests() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.requests...)
+}
+
+//Writes returns the number of requests that changed a repo description
+func (r *Registry) Writes() int {
+	n := 0
+	for _, req := range r.Requests() {
+		if strings.HasPrefix(req, "PUT ") || strings.HasPrefix(req, "PATCH ") {
+			n++
+		}
+	}
+	return n
+}
+
+// serveHTTP routes a request to the fake of the provider api
+func (r *Registry) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	delay := r.delay
+	r.mu.Unlock()
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.failures) > 0 {
+		f := r.failures[0]
+		r.failures = r.failures[1:]
+		for key, values := range f.header {
+			w.Header()[key] = values
+		}
+		writeJSON(w, f.statusCode, map[string]interface{}{"detail": http.StatusText(f.statusCode), "message": http.StatusText(f.statusCode)})
+		return
+	}
+
+	path := req.URL.EscapedPath()
+	r.requests = append(r.requests, req.Method+" "+path)
+
+	var body map[string]string
+	if req.Body != nil {
+		data, _ := ioutil.ReadAll(req.Body)
+		json.Unmarshal(data, &body)
+	}
+
+	switch {
+	case path == "/v2/users/login/" && req.Method == "POST":
+		r.dockerhubLogin(w, body)
+	case path == "/v2/users/2fa-login/" && req.Method == "POST":
+		r.dockerhub2FALogin(w, body)
+	case path == "/v2/auth/token" && req.Method == "POST":
+		r.dockerhubAccessToken(w, body)
+	case strings.HasPrefix(path, "/v2/repositories/"):
+		r.dockerhubRepo(w, req, strings.Trim(strings.TrimPrefix(path, "/v2/repositories/"), "/"), body)
+	case path == "/api/v1/user/" && req.Method == "GET":
+		r.tokenUser(w, strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "), "error_message")
+	case strings.HasPrefix(path, "/api/v1/repository/"):
+		r.quayRepo(w, req, strings.TrimPrefix(path, "/api/v1/repository/"), body)
+	case strings.HasPrefix(path, "/api/v2.0/projects/"):
+		r.harborRepo(w, req, strings.TrimPrefix(path, "/api/v2.0/projects/"), body)
+	case path == "/api/v4/user" && req.Method == "GET":
+		r.tokenUser(w, req.Header.Get("PRIVATE-TOKEN"), "message")
+	case strings.HasPrefix(path, "/api/v4/projects/"):
+		r.gitlabProject(w, req, strings.TrimPrefix(path, "/api/v4/projects/"), body)
+	default:
+		writeJSON(w, 404, map[string]interface{}{"message": "404 Not Found"})
+	}
+}
+
+// --- Dockerhub ---
+
+func (r *Registry) dockerhubLogin(w http.ResponseWriter, body map[string]string) {
+	user := body["username"]
+	if passwd, ok := r.users[user]; !ok || passwd != body["password"] {
+		writeJSON(w, 401, map[string]interface{}{"detail": "Incorrect authentication credentials"})
+		return
+	}
+	if _, ok := r.totp[user]; ok {
+		writeJSON(w, 401, map[string]interface{}{"detail": "Require secondary authentication on MFA enabled account", "login_2fa_token": "2fa-" + user})
+		return
+	}
+	writeJSON(w, 200, map[string]interface{}{"token": r.issue(user)})
+}
+
+func (r *Registry) dockerhub2FALogin(w http.ResponseWriter, body map[string]string) {
+	user := strings.TrimPrefix(body["login_2fa_token"], "2fa-")
+	if code, ok := r.totp[user]; !ok || code != body["code"] {
+		writeJSON(w, 401, map[string]interface{}{"detail": "Incorrect authentication credentials"})
+		return
+	}
+	writeJSON(w, 200, map[string]interface{}{"token": r.issue(user)})
+}
+
+func (r *Registry) dockerhubAccessToken(w http.ResponseWriter, body map[string]string) {
+	if owner, ok := r.tokens[body["secret"]]; !ok || owner != body["identifier"] {
+		writeJSON(w, 401, map[string]interface{}{"message": "invalid credentials"})
+		return
+	}
+	writeJSON(w, 200, map[string]interface{}{"access_token": r.issue(body["identifier"])})
+}
+
+// issue returns a new jwt for a user
+func (r *Registry) issue(user string) string {
+	jwt := fmt.Sprintf("jwt-%s-%d", user, len(r.issued)+1)
+	r.issued[jwt] = user
+	return jwt
+}
+
+func (r *Registry) dockerhubRepo(w http.ResponseWriter, req *http.Request, path string, body map[string]string) {
+	auth := req.Header.Get("Authorization")
+	user, ok := r.issued[strings.TrimPrefix(strings.TrimPrefix(auth, "JWT "), "Bearer ")]
+	if !ok {
+		writeJSON(w, 401, map[string]interface{}{"detail": "Authentication credentials were not provided."})
+		return
+	}
+	repo, ok := r.repos[path]
+	if !ok {
+		writeJSON(w, 404, map[string]interface{}{"detail": "Object not found"})
+		return
+	}
+
+	switch req.Method {
+	case "GET":
+	case "PATCH":
+		if r.readonly[user] {
+			writeJSON(w, 403, map[string]interface{}{"detail": "You do not have permission to perform this action."})
+			return
+		}
+		if readme, ok := body["full_description"]; ok {
+			repo.Readme = readme
+		}
+		if shortdesc, ok := body["description"]; ok {
+			repo.Shortdesc = shortdesc
+		}
+	default:
+		writeJSON(w, 405, map[string]interface{}{"detail": "Method not allowed"})
+		return
+	}
+	writeJSON(w, 200, map[string]interface{}{"full_description": repo.Readme, "description": repo.Shortdesc})
+}
+
+// --- Quay ---
+
+func (r *Registry) quayRepo(w http.ResponseWriter, req *http.Request, path string, body map[string]string) {
+	owner, ok := r.tokens[strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")]
+	if !ok {
+		writeJSON(w, 401, map[string]interface{}{"error_message": "Invalid Token"})
+		return
+	}
+	repo, ok := r.repos[path]
+	if !ok {
+		writeJSON(w, 404, map[string]interface{}{"error_message": "Not Found"})
+		return
+	}
+
+	switch req.Method {
+	case "GET":
+	case "PUT":
+		if r.readonly[owner] {
+			writeJSON(w, 403, map[string]interface{}{"error_message": "Unauthorized"})
+			return
+		}
+		repo.Readme = body["description"]
+	default:
+		writeJSON(w, 405, map[string]interface{}{"error_message": "Method Not Allowed"})
+		return
+	}
+	writeJSON(w, 200, map[string]interface{}{"description": repo.Readme})
+}
+
+// --- Harbor v2 ---
+
+func (r *Registry) harborRepo(w http.ResponseWriter, req *http.Request, path string, body map[string]string) {
+	user, ok := r.harborUser(req)
+	if !ok {
+		writeJSON(w, 401, harborError("UNAUTHORIZED", "unauthorized"))
+		return
+	}
+
+	// <project>/repositories/<repo, url encoded twice>
+	parts := strings.SplitN(path, "/repositories/", 2)
+	if len(parts) != 2 {
+		writeJSON(w, 404, harborError("NOT_FOUND", "not found"))
+		return
+	}
+	project, _ := url.PathUnescape(parts[0])
+	reponame, _ := url.PathUnescape(parts[1])
+	reponame, _ = url.PathUnescape(reponame)
+	repo, ok := r.repos[project+"/"+reponame]
+	if !ok {
+		writeJSON(w, 404, harborError("NOT_FOUND", "repository "+project+"/"+reponame+" not found"))
+		return
+	}
+
+	switch req.Method {
+	case "GET":
+		writeJSON(w, 200, map[string]interface{}{"name": project + "/" + reponame, "description": repo.Readme})
+	case "PUT":
+		if r.readonly[user] {
+			writeJSON(w, 403, harborError("FORBIDDEN", "forbidden"))
+			return
+		}
+		repo.Readme = body["description"]
+		w.WriteHeader(200)
+	default:
+		writeJSON(w, 405, harborError("METHOD_NOT_ALLOWED", "method not allowed"))
+	}
+}
+
+// harborUser checks basic auth (user/password) or a bearer token
+func (r *Registry) harborUser(req *http.Request) (string, bool) {
+	auth := req.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") {
+		owner, ok := r.tokens[strings.TrimPrefix(auth, "Bearer ")]
+		return owner, ok
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(auth, "Basic "))
+	if err != nil {
+		return "", false
+	}
+	creds := strings.SplitN(string(data), ":", 2)
+	if len(creds) != 2 {
+		return "", false
+	}
+	if passwd, ok := r.users[creds[0]]; !ok || passwd != creds[1] {
+		return "", false
+	}
+	return creds[0], true
+}
+
+func harborError(code string, message string) map[string]interface{} {
+	return map[string]interface{}{"errors": []interface{}{map[string]interface{}{"code": code, "message": message}}}
+}
+
+// --- GitLab ---
+
+func (r *Registry) gitlabProject(w http.ResponseWriter, req *http.Request, path string, body map[string]string) {
+	token := req.Header.Get("PRIVATE-TOKEN")
+	jobToken := token == ""
+	if jobToken {
+		token = req.Header.Get("JOB-TOKEN")
+	}
+	owner, ok := r.tokens[token]
+	if !ok {
+		writeJSON(w, 401, map[string]interface{}{"message": "401 Unauthorized"})
+		return
+	}
+
+	// the project path is url encoded
+	listImages := strings.HasSuffix(path, "/registry/repositories")
+	path = strings.TrimSuffix(path, "/registry/repositories")
+	projectpath, _ := url.PathUnescape(path)
+	repo, ok := r.repos[projectpath]
+	if !ok {
+		writeJSON(w, 404, map[string]interface{}{"message": "404 Project Not Found"})
+		return
+	}
+
+	if listImages {
+		images := []interface{}{}
+		for image := range r.images {
+			if strings.HasPrefix(image, projectpath+"/") {
+				images = append(images, map[string]interface{}{"path": image, "name": strings.TrimPrefix(image, projectpath+"/")})
+			}
+		}
+		writeJSON(w, 200, images)
+		return
+	}
+
+	switch req.Method {
+	case "GET":
+	case "PUT":
+		// CI job tokens can't edit the project
+		if r.readonly[owner] || jobToken {
+			writeJSON(w, 403, map[string]interface{}{"message": "403 Forbidden"})
+			return
+		}
+		repo.Readme = body["description"]
+	default:
+		writeJSON(w, 405, map[string]interface{}{"message": "405 Method Not Allowed"})
+		return
+	}
+	writeJSON(w, 200, map[string]interface{}{"path_with_namespace": projectpath, "description": repo.Readme})
+}
+
+// tokenUser returns the owner of an api token (Quay and GitLab)
+func (r *Registry) tokenUser(w http.ResponseWriter, token string, errorField string) {
+	owner, ok := r.tokens[token]
+	if !ok || token == "" {
+		writeJSON(w, 401, map[string]interface{}{errorField: "401 Unauthorized"})
+		return
+	}
+	writeJSON(w, 200, map[string]interface{}{"username": owner})
+}
+
+func writeJSON(w http.ResponseWriter, status int, dat interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(dat)
+}
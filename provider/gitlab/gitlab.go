@@ -0,0 +1,451 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/christian-korneck/docker-pushrm/provider/provider"
+	"github.com/christian-korneck/docker-pushrm/util"
+	log "github.com/sirupsen/logrus"
+)
+
+//Gitlab struct
+type Gitlab struct {
+}
+
+//Token is a GitLab api token together with the http header it gets sent in
+type Token struct {
+	Header string
+	Value  string
+}
+
+//Pushrm is the main provider function
+func (f Gitlab) Pushrm(ctx context.Context, target provider.Target, creds provider.Credentials, content provider.Content) (provider.Result, error) {
+
+	log.Debug("Gitlab.Pushrm called")
+	content = content.Supported(f.Capabilities())
+
+	token, err := GetToken(target.Servername)
+	if err != nil {
+		return provider.Result{}, err
+	}
+
+	apiurl := GetAPIURL(target.Servername)
+	log.Debug("GitLab api url: ", apiurl)
+
+	projectpath, remoteReadme, err := FindProject(ctx, token, apiurl, target.Repository())
+	if err != nil {
+		log.Debug(err)
+		return provider.Result{}, fmt.Errorf("error pushing readme to repo server. See error message below. Run with \"--debug\" for more details. \n\n%w", err)
+	}
+
+	// skip the write if the repo server already has the same content
+	if content.Matches(provider.Content{Readme: remoteReadme}) {
+		log.Debug("remote content matches, skipping push")
+		return provider.Result{Action: provider.ActionUnchanged, URL: strings.TrimSuffix(apiurl, "/api/v4") + "/" + projectpath}, nil
+	}
+
+	// job tokens can read the project, but aren't allowed to edit its description
+	if token.Header == "JOB-TOKEN" {
+		return provider.Result{}, provider.Errorf(provider.ErrorPermission, "error pushing readme to repo server, the CI job token (CI_JOB_TOKEN) isn't allowed to edit the project description. Use a project or personal access token with \"api\" scope instead (env var GITLAB_TOKEN)")
+	}
+
+	err = PatchDescription(ctx, token, apiurl, projectpath, content.Readme)
+	if err != nil {
+		log.Debug(err)
+		return provider.Result{}, fmt.Errorf("error pushing readme to repo server. See error message below. Run with \"--debug\" for more details. \n\n%w", err)
+	}
+
+	return provider.Result{Action: provider.ActionUpdated, URL: strings.TrimSuffix(apiurl, "/api/v4") + "/" + projectpath}, nil
+}
+
+//Pullrm reads the current repo description
+func (f Gitlab) Pullrm(ctx context.Context, target provider.Target, creds provider.Credentials) (provider.Content, error) {
+
+	log.Debug("Gitlab.Pullrm called")
+
+	token, err := GetToken(target.Servername)
+	if err != nil {
+		return provider.Content{}, err
+	}
+
+	_, readme, err := FindProject(ctx, token, GetAPIURL(target.Servername), target.Repository())
+	if err != nil {
+		log.Debug(err)
+		return provider.Content{}, fmt.Errorf("error fetching readme from repo server. See error message below. Run with \"--debug\" for more details. \n\n%w", err)
+	}
+
+	return provider.Content{Readme: readme}, nil
+}
+
+//GetAuthident returns authident for local Docker credentials store
+func (f Gitlab) GetAuthident() (authident string) {
+	log.Debug("Gitlab.GetAuthident called")
+	authident = "__NONE__"
+	return
+}
+
+//ValidateToken checks a GitLab personal (or project/group) access token by reading the user that it belongs to
+func (f Gitlab) ValidateToken(ctx context.Context, servername string, token string) (owner string, error error) {
+
+	log.Debug("Gitlab.ValidateToken called")
+
+	apiurl := GetAPIURL(servername)
+	requrl := apiurl + "/user"
+
+	client, err := util.NewHTTPClient(apiHost(apiurl))
+	if err != nil {
+		log.Debug(err)
+		return "", fmt.Errorf("error validating token, error creating http client: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", requrl, nil)
+	if err != nil {
+		log.Debug(err)
+		return "", fmt.Errorf("error validating token, error creating http request")
+	}
+
+	req.Header.Add("PRIVATE-TOKEN", token)
+
+	res, err := client.Do(req)
+	if err != nil {
+		log.Debug(err)
+		return "", provider.RequestError(err, "error validating token, error making http request")
+	}
+
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		log.Debug(err)
+		return "", provider.RequestError(err, "error validating token, error reading response body")
+	}
+
+	var dat map[string]interface{}
+	if err := json.Unmarshal(body, &dat); err != nil {
+		log.Debug(err)
+	}
+
+	log.Debug("validate token, status code: ", res.StatusCode)
+
+	if res.StatusCode != 200 {
+		return "", provider.StatusError(res.StatusCode, errorMessage("error validating token", res, dat))
+	}
+
+	owner, _ = dat["username"].(string)
+
+	return owner, nil
+}
+
+//Capabilities returns the features supported by GitLab
+func (f Gitlab) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		ShortDescription: false,
+		TagReadme:        false,
+		ReadBack:         true,
+		NestedPaths:      true,
+		// GitLab limits project descriptions to 2000 characters
+		MaxReadmeSize: 2000,
+	}
+}
+
+//GetToken retrieves a GitLab api token. A personal, group or project access token (env var GITLAB_TOKEN or api key) takes precedence over a CI job token (env var CI_JOB_TOKEN).
+//Job tokens can only read the project description.
+func GetToken(servername string) (token Token, error error) {
+	if envval := os.Getenv("GITLAB_TOKEN"); envval != "" {
+		log.Debug("using GitLab token from env var GITLAB_TOKEN")
+		return Token{Header: "PRIVATE-TOKEN", Value: envval}, nil
+	}
+
+	apikey, err := util.GetApikey(servername)
+	if err == nil {
+		log.Debug("using GitLab token from api key")
+		return Token{Header: "PRIVATE-TOKEN", Value: apikey}, nil
+	}
+
+	if envval := os.Getenv("CI_JOB_TOKEN"); envval != "" {
+		log.Debug("using GitLab CI job token from env var CI_JOB_TOKEN (read only)")
+		return Token{Header: "JOB-TOKEN", Value: envval}, nil
+	}
+
+	return Token{}, provider.Errorf(provider.ErrorAuth, "could not find a GitLab token for server %s. Either specify env var GITLAB_TOKEN or an api key (see \"--help\") or run in GitLab CI (env var CI_JOB_TOKEN, read only).", servername)
+}
+
+//GetAPIURL returns the GitLab api url for a registry server. Env var PUSHRM_GITLAB_URL (GitLab instance url) takes precedence, then env var CI_API_V4_URL (set in GitLab CI). Otherwise the url gets derived from the registry servername (registry.example.com -> example.com, the port gets removed)
+func GetAPIURL(servername string) string {
+	if instanceurl := util.APIBaseURL("gitlab", ""); instanceurl != "" {
+		return instanceurl + "/api/v4"
+	}
+	if envval := os.Getenv("CI_API_V4_URL"); envval != "" {
+		return strings.TrimSuffix(envval, "/")
+	}
+	// the registry often runs on a separate port of the GitLab host (i.e. gitlab.example.com:5050)
+	hostname := servername
+	if host, _, err := net.SplitHostPort(servername); err == nil {
+		hostname = host
+		if strings.Contains(host, ":") {
+			hostname = "[" + host + "]"
+		}
+	}
+	return util.BaseURL(strings.TrimPrefix(hostname, "registry.")) + "/api/v4"
+}
+
+//FindProject maps an image path (group/subgroup/project/image) to the GitLab project that owns the registry repository. Returns the project path and its current description
+func FindProject(ctx context.Context, token Token, apiurl string, imagepath string) (projectpath string, description string, error error) {
+	segments := strings.Split(imagepath, "/")
+
+	// the image name below the project path is optional, try the longest path first
+	for i := len(segments); i >= 2; i-- {
+		candidate := strings.Join(segments[:i], "/")
+		description, found, err := GetDescription(ctx, token, apiurl, candidate)
+		if err != nil {
+			return "", "", err
+		}
+		if !found {
+			log.Debug("no GitLab project found for path: ", candidate)
+			continue
+		}
+		log.Debug("found GitLab project: ", candidate)
+		if i == len(segments) {
+			return candidate, description, nil
+		}
+
+		// a shorter path only belongs to the image if the project has a registry repository with this path
+		found, err = HasRegistryRepository(ctx, token, apiurl, candidate, imagepath)
+		if err != nil {
+			return "", "", err
+		}
+		if !found {
+			return "", "", provider.Errorf(provider.ErrorNotFound, "error finding GitLab project for image path %s. The project %s has no registry repository %s", imagepath, candidate, imagepath)
+		}
+		return candidate, description, nil
+	}
+
+	return "", "", provider.Errorf(provider.ErrorNotFound, "error finding GitLab project for image path %s. Make sure that the project exists and that the token has access to it", imagepath)
+}
+
+//HasRegistryRepository - api call to check if a project has a container registry repository with the given path
+func HasRegistryRepository(ctx context.Context, token Token, apiurl string, projectpath string, imagepath string) (found bool, error error) {
+
+	client, err := util.NewHTTPClient(apiHost(apiurl))
+	if err != nil {
+		log.Debug(err)
+		return false, fmt.Errorf("error listing registry repositories, error creating http client: %w", err)
+	}
+
+	// the list is paginated, the header X-Next-Page is empty on the last page
+	for page := "1"; page != ""; {
+		requrl := apiurl + "/projects/" + url.PathEscape(projectpath) + "/registry/repositories?per_page=100&page=" + page
+
+		req, err := http.NewRequestWithContext(ctx, "GET", requrl, nil)
+		if err != nil {
+			log.Debug(err)
+			return false, fmt.Errorf("error listing registry repositories, error creating http request")
+		}
+
+		req.Header.Add(token.Header, token.Value)
+
+		res, err := client.Do(req)
+		if err != nil {
+			log.Debug(err)
+			return false, provider.RequestError(err, "error listing registry repositories, error making http request")
+		}
+
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			log.Debug(err)
+			return false, provider.RequestError(err, "error listing registry repositories, error reading response body")
+		}
+
+		log.Debug("list registry repositories, status code: ", res.StatusCode)
+
+		if res.StatusCode != 200 {
+			var dat map[string]interface{}
+			if err := json.Unmarshal(body, &dat); err != nil {
+				log.Debug(err)
+			}
+			return false, provider.StatusError(res.StatusCode, errorMessage("error listing registry repositories", res, dat))
+		}
+
+		var repositories []struct {
+			Path string `json:"path"`
+		}
+		if err := json.Unmarshal(body, &repositories); err != nil {
+			log.Debug(err)
+			return false, fmt.Errorf("error listing registry repositories, error parsing response body")
+		}
+		for _, repository := range repositories {
+			if strings.EqualFold(repository.Path, imagepath) {
+				return true, nil
+			}
+		}
+
+		page = res.Header.Get("X-Next-Page")
+	}
+
+	return false, nil
+}
+
+//GetDescription - api call to read the project description. found is false if the project doesn't exist
+func GetDescription(ctx context.Context, token Token, apiurl string, projectpath string) (description string, found bool, error error) {
+
+	// the project path is used as url encoded project id
+	requrl := apiurl + "/projects/" + url.PathEscape(projectpath)
+	method := "GET"
+
+	client, err := util.NewHTTPClient(apiHost(apiurl))
+	if err != nil {
+		log.Debug(err)
+		return "", false, fmt.Errorf("error fetching README, error creating http client: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, requrl, nil)
+	if err != nil {
+		log.Debug(err)
+		return "", false, fmt.Errorf("error fetching README, error creating http request")
+	}
+
+	req.Header.Add(token.Header, token.Value)
+
+	res, err := client.Do(req)
+	if err != nil {
+		log.Debug(err)
+		return "", false, provider.RequestError(err, "error fetching README, error making http request")
+	}
+
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		log.Debug(err)
+		return "", false, provider.RequestError(err, "error fetching README, error reading response body")
+	}
+
+	log.Debug("fetch README, status code: ", res.StatusCode)
+
+	if res.StatusCode == 404 {
+		return "", false, nil
+	}
+
+	var dat map[string]interface{}
+	if err := json.Unmarshal(body, &dat); err != nil {
+		log.Debug(err)
+	}
+
+	if res.StatusCode != 200 {
+		return "", false, provider.StatusError(res.StatusCode, errorMessage("error fetching README", res, dat))
+	}
+
+	description, _ = dat["description"].(string)
+
+	return description, true, nil
+}
+
+//PatchDescription - api call to update the project description
+func PatchDescription(ctx context.Context, token Token, apiurl string, projectpath string, readme string) (error error) {
+
+	requrl := apiurl + "/projects/" + url.PathEscape(projectpath)
+	method := "PUT"
+
+	jsonbody, _ := json.Marshal(map[string]string{"description": readme})
+	payload := strings.NewReader(string(jsonbody))
+
+	client, err := util.NewHTTPClient(apiHost(apiurl))
+	if err != nil {
+		log.Debug(err)
+		return fmt.Errorf("error pushing README, error creating http client: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, requrl, payload)
+	if err != nil {
+		log.Debug(err)
+		return fmt.Errorf("error pushing README, error creating http request")
+	}
+
+	req.Header.Add(token.Header, token.Value)
+	req.Header.Add("Content-Type", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		log.Debug(err)
+		return provider.RequestError(err, "error pushing README, error making http request")
+	}
+
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		log.Debug(err)
+		return provider.RequestError(err, "error pushing README, error reading response body")
+	}
+
+	log.Debug("push readme, response body: " + string(body))
+
+	var dat map[string]interface{}
+	if err := json.Unmarshal(body, &dat); err != nil {
+		log.Debug(err)
+	}
+
+	log.Debug("push README, status code: ", res.StatusCode)
+
+	if res.StatusCode != 200 {
+		return provider.StatusError(res.StatusCode, errorMessage("error pushing README", res, dat))
+	}
+
+	if dat["description"] != readme {
+		return provider.Errorf(provider.ErrorValidation, "error pushing README, pushed readme to repo server but validation failed")
+	}
+
+	log.Debug("content validation successfull, readme successfully pushed to repo server")
+	return nil
+}
+
+// apiHost returns the host (with optional port) of the api url
+func apiHost(apiurl string) string {
+	u, err := url.Parse(apiurl)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// errorMessage builds an error message from a failed GitLab api response
+func errorMessage(prefix string, res *http.Response, dat map[string]interface{}) string {
+	msg := prefix + ", bad status code for response: " + res.Status
+	if dat["message"] != nil {
+		msg = msg + ". Server responded: \"" + fmt.Sprint(dat["message"]) + "\""
+	} else if dat["error"] != nil {
+		msg = msg + ". Server responded: \"" + fmt.Sprint(dat["error"]) + "\""
+	}
+	if res.StatusCode == 401 {
+		msg = msg + ". Check that the GitLab token is valid."
+	}
+	if res.StatusCode == 403 {
+		msg = msg + ". Make sure the token has \"api\" scope and at least the \"Maintainer\" role in the project."
+	}
+	return msg
+}
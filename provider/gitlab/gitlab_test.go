@@ -0,0 +1,86 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gitlab
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/christian-korneck/docker-pushrm/provider/provider"
+	"github.com/christian-korneck/docker-pushrm/provider/providertest"
+	"github.com/spf13/viper"
+)
+
+func TestConformance(t *testing.T) {
+	providertest.Run(t, providertest.Suite{
+		Provider: Gitlab{},
+		Target:   provider.Target{Servername: "registry.gitlab.example.com:5050", Path: []string{"my-group", "my-project"}},
+		Setup: func(t *testing.T, url string) {
+			viper.Set("gitlab-url", url)
+			old, existed := os.LookupEnv("GITLAB_TOKEN")
+			os.Setenv("GITLAB_TOKEN", "my-token")
+			t.Cleanup(func() {
+				viper.Set("gitlab-url", "")
+				if existed {
+					os.Setenv("GITLAB_TOKEN", old)
+				} else {
+					os.Unsetenv("GITLAB_TOKEN")
+				}
+			})
+		},
+		Classify: func(r *http.Request) providertest.Request {
+			// the project path is the url encoded project id
+			switch {
+			case r.URL.EscapedPath() != "/api/v4/projects/my-group%2Fmy-project" || r.Header.Get("PRIVATE-TOKEN") != "my-token":
+				return providertest.RequestUnknown
+			case r.Method == "GET":
+				return providertest.RequestRead
+			case r.Method == "PUT":
+				return providertest.RequestWrite
+			}
+			return providertest.RequestUnknown
+		},
+		RepoResponse: func(remote provider.Content) map[string]interface{} {
+			return map[string]interface{}{"id": 42, "path_with_namespace": "my-group/my-project", "description": remote.Readme}
+		},
+		ErrorResponse: func(statusCode int, message string) map[string]interface{} {
+			return map[string]interface{}{"message": message}
+		},
+		DecodeWrite: func(body []byte) (provider.Content, error) {
+			var dat map[string]interface{}
+			if err := json.Unmarshal(body, &dat); err != nil {
+				return provider.Content{}, err
+			}
+			readme, ok := dat["description"].(string)
+			if !ok || len(dat) != 1 {
+				return provider.Content{}, errors.New("expected only the field description")
+			}
+			return provider.Content{Readme: readme}, nil
+		},
+		// the project gets looked up before the write
+		ReadBeforeWrite: true,
+	})
+}
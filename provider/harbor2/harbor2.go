@@ -23,13 +23,16 @@ THE SOFTWARE.
 package harbor2
 
 import (
-	"encoding/base64"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
 
+	"github.com/christian-korneck/docker-pushrm/provider/provider"
+	"github.com/christian-korneck/docker-pushrm/util"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -38,21 +41,49 @@ type Harbor2 struct {
 }
 
 //Pushrm is the main provider function
-func (f Harbor2) Pushrm(servername string, namespacename string, reponame string, tagname string, dockerUser string, dockerPasswd string, readme string, shortdesc string) error {
+func (f Harbor2) Pushrm(ctx context.Context, target provider.Target, creds provider.Credentials, content provider.Content) (provider.Result, error) {
 
-	if shortdesc != "" {
-		log.Warn("Short description not supported for provider \"harbor2\". Ignoring.")
+	log.Debug("Harbor2.Pushrm called")
+	content = content.Supported(f.Capabilities())
+
+	if err := checkCredentials(target, creds); err != nil {
+		return provider.Result{}, err
 	}
 
-	log.Debug("Harbor2.Pushrm called")
+	// skip the write if the repo server already has the same content
+	remoteReadme, err := GetDescription(ctx, creds.Authorization(), target.Servername, projectName(target), repoName(target))
+	if err != nil {
+		log.Debug("could not fetch current repo description, pushing anyway: ", err)
+	} else if content.Matches(provider.Content{Readme: remoteReadme}) {
+		log.Debug("remote content matches, skipping push")
+		return provider.Result{Action: provider.ActionUnchanged, URL: repoURL(target.Servername, projectName(target), repoName(target))}, nil
+	}
 
-	err := PatchDescription(dockerUser, dockerPasswd, readme, servername, namespacename, reponame)
+	err = PatchDescription(ctx, creds.Authorization(), content.Readme, target.Servername, projectName(target), repoName(target))
 	if err != nil {
 		log.Debug(err)
-		return fmt.Errorf("error pushing readme to repo server. See error message below. Run with \"--debug\" for more details. \n\n" + err.Error())
+		return provider.Result{}, fmt.Errorf("error pushing readme to repo server. See error message below. Run with \"--debug\" for more details. \n\n%w", err)
 	}
 
-	return nil
+	return provider.Result{Action: provider.ActionUpdated, URL: repoURL(target.Servername, projectName(target), repoName(target))}, nil
+}
+
+//Pullrm reads the current repo description
+func (f Harbor2) Pullrm(ctx context.Context, target provider.Target, creds provider.Credentials) (provider.Content, error) {
+
+	log.Debug("Harbor2.Pullrm called")
+
+	if err := checkCredentials(target, creds); err != nil {
+		return provider.Content{}, err
+	}
+
+	readme, err := GetDescription(ctx, creds.Authorization(), target.Servername, projectName(target), repoName(target))
+	if err != nil {
+		log.Debug(err)
+		return provider.Content{}, fmt.Errorf("error fetching readme from repo server. See error message below. Run with \"--debug\" for more details. \n\n%w", err)
+	}
+
+	return provider.Content{Readme: readme}, nil
 }
 
 //GetAuthident returns authident for local Docker credentials store
@@ -62,37 +93,76 @@ func (f Harbor2) GetAuthident() (authident string) {
 	return
 }
 
+//Capabilities returns the features supported by Harbor2
+func (f Harbor2) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		ShortDescription: false,
+		TagReadme:        false,
+		ReadBack:         false,
+		NestedPaths:      true,
+		// Harbor stores the repo description in a text column without a length limit
+		MaxReadmeSize: 0,
+	}
+}
+
+// checkCredentials rejects the identity token of an OIDC login, Harbor's api needs a robot account or a CLI secret
+func checkCredentials(target provider.Target, creds provider.Credentials) error {
+	if creds.Type() == provider.CredentialIdentityToken {
+		return provider.Errorf(provider.ErrorAuth, "the Docker login for %s is an OIDC identity token, which isn't supported for Harbor. Use a robot account or the CLI secret from your Harbor user profile as password instead", target.Servername)
+	}
+	return nil
+}
+
+// projectName returns the Harbor project (first path component)
+func projectName(target provider.Target) string {
+	return target.Path[0]
+}
+
+// repoName returns the repo name inside of the Harbor project (can contain "/" for nested repos)
+func repoName(target provider.Target) string {
+	return strings.Join(target.Path[1:], "/")
+}
+
+// repoURL returns the api url of a repo. Harbor expects slashes in nested repo names to be url encoded twice
+func repoURL(servername string, namespacename string, reponame string) string {
+	return util.APIBaseURL("harbor2", util.BaseURL(servername)) + "/api/v2.0/projects/" + url.PathEscape(namespacename) + "/repositories/" + url.PathEscape(url.PathEscape(reponame))
+}
+
 //PatchDescription - api call to update the repo description
-func PatchDescription(dockerUser string, dockerPasswd string, readme string, servername string, namespacename string, reponame string) (error error) {
+func PatchDescription(ctx context.Context, auth string, readme string, servername string, namespacename string, reponame string) (error error) {
 
-	apiurl := "https://" + servername + "/api/v2.0/projects/" + namespacename + "/repositories/" + reponame
+	apiurl := repoURL(servername, namespacename, reponame)
 	method := "PUT"
 
 	jsonbody, _ := json.Marshal(map[string]string{"description": readme})
 	payload := strings.NewReader(string(jsonbody))
 
-	client := &http.Client{}
-	req, err := http.NewRequest(method, apiurl, payload)
+	client, err := util.NewHTTPClient(servername)
+	if err != nil {
+		log.Debug(err)
+		return fmt.Errorf("error pushing README, error creating http client: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, apiurl, payload)
 	if err != nil {
 		log.Debug(err)
 		return fmt.Errorf("error pushing README, error creating http request")
 	}
 
-	creds := base64.StdEncoding.EncodeToString([]byte(dockerUser + ":" + dockerPasswd))
-	req.Header.Add("Authorization", "Basic "+creds)
+	// basic auth, or bearer token for OIDC logins
+	req.Header.Add("Authorization", auth)
 	req.Header.Add("Content-Type", "application/json")
 
 	res, err := client.Do(req)
 	if err != nil {
 		log.Debug(err)
-		return fmt.Errorf("error pushing README, error creating http request")
+		return provider.RequestError(err, "error pushing README, error creating http request")
 	}
 
 	defer res.Body.Close()
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		log.Debug(err)
-		return fmt.Errorf("error pushing README, error reading response body")
+		return provider.RequestError(err, "error pushing README, error reading response body")
 	}
 
 	log.Debug("push readme, response body: " + string(body))
@@ -109,16 +179,16 @@ func PatchDescription(dockerUser string, dockerPasswd string, readme string, ser
 
 	if res.StatusCode != 200 {
 		msg := "error pushing README, bad status code for response: " + res.Status
-		if dat["errors"] != nil {
-
-			firsterror := dat["errors"].([]interface{})[0].(map[string]interface{})
-			msg = msg + ". Server responded: \"" + firsterror["code"].(string) + " - " + firsterror["message"].(string) + "\""
+		if errs, ok := dat["errors"].([]interface{}); ok && len(errs) > 0 {
+			if firsterror, ok := errs[0].(map[string]interface{}); ok {
+				msg = msg + ". Server responded: \"" + fmt.Sprint(firsterror["code"]) + " - " + fmt.Sprint(firsterror["message"]) + "\""
+			}
 		}
 		if res.StatusCode == 403 {
 			msg = msg + ". Try \"docker logout\" and \"docker login\". "
 
 		}
-		return fmt.Errorf(msg)
+		return provider.StatusError(res.StatusCode, msg)
 
 	} else {
 		log.Debug("status code OK, readme successfully pushed to repo server")
@@ -126,3 +196,58 @@ func PatchDescription(dockerUser string, dockerPasswd string, readme string, ser
 	}
 
 }
+
+//GetDescription - api call to read the repo description
+func GetDescription(ctx context.Context, auth string, servername string, namespacename string, reponame string) (readme string, error error) {
+
+	apiurl := repoURL(servername, namespacename, reponame)
+	method := "GET"
+
+	client, err := util.NewHTTPClient(servername)
+	if err != nil {
+		log.Debug(err)
+		return "", fmt.Errorf("error fetching README, error creating http client: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, apiurl, nil)
+	if err != nil {
+		log.Debug(err)
+		return "", fmt.Errorf("error fetching README, error creating http request")
+	}
+
+	// basic auth, or bearer token for OIDC logins
+	req.Header.Add("Authorization", auth)
+
+	res, err := client.Do(req)
+	if err != nil {
+		log.Debug(err)
+		return "", provider.RequestError(err, "error fetching README, error making http request")
+	}
+
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		log.Debug(err)
+		return "", provider.RequestError(err, "error fetching README, error reading response body")
+	}
+
+	var dat map[string]interface{}
+	if err := json.Unmarshal(body, &dat); err != nil {
+		log.Debug(err)
+	}
+
+	log.Debug("fetch README, status code: ", res.StatusCode)
+
+	if res.StatusCode != 200 {
+		msg := "error fetching README, bad status code for response: " + res.Status
+		if errs, ok := dat["errors"].([]interface{}); ok && len(errs) > 0 {
+			if firsterror, ok := errs[0].(map[string]interface{}); ok {
+				msg = msg + ". Server responded: \"" + fmt.Sprint(firsterror["code"]) + " - " + fmt.Sprint(firsterror["message"]) + "\""
+			}
+		}
+		return "", provider.StatusError(res.StatusCode, msg)
+	}
+
+	readme, _ = dat["description"].(string)
+
+	return readme, nil
+}
@@ -0,0 +1,125 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+//ErrorKind classifies provider errors, so that all providers report the same failure in the same way
+type ErrorKind string
+
+const (
+	//ErrorAuth - the credentials were rejected (http 401)
+	ErrorAuth ErrorKind = "auth"
+	//ErrorPermission - the credentials are valid, but lack the permission (http 403)
+	ErrorPermission ErrorKind = "permission"
+	//ErrorNotFound - the repo doesn't exist or isn't visible with the credentials (http 404)
+	ErrorNotFound ErrorKind = "notfound"
+	//ErrorValidation - the repo server rejected the content (i.e. too large) or stored something else than was pushed
+	ErrorValidation ErrorKind = "validation"
+	//ErrorNetwork - the repo server couldn't be reached, the connection broke or the server is unavailable or rate limits
+	//the client (http 408, 429, 5xx; transient, a retry might help)
+	ErrorNetwork ErrorKind = "network"
+	//ErrorTimeout - the repo server didn't respond in time (see "--timeout" and "--request-timeout")
+	ErrorTimeout ErrorKind = "timeout"
+	//ErrorCanceled - the call was canceled (i.e. with Ctrl-C)
+	ErrorCanceled ErrorKind = "canceled"
+)
+
+//Error is a provider error with a kind
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+//Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+//Errorf returns an error of a kind
+func Errorf(kind ErrorKind, format string, a ...interface{}) error {
+	return &Error{Kind: kind, Err: fmt.Errorf(format, a...)}
+}
+
+//StatusError returns an error for a failed api call, with the kind derived from the http status code
+func StatusError(statusCode int, msg string) error {
+	kind := StatusErrorKind(statusCode)
+	if kind == "" {
+		return errors.New(msg)
+	}
+	return &Error{Kind: kind, Err: errors.New(msg)}
+}
+
+//RequestError returns an error for an api call that failed without a response (or while reading it). The kind is
+//ErrorTimeout or ErrorCanceled if the call ran out of time or was canceled, otherwise ErrorNetwork.
+func RequestError(err error, msg string) error {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return Errorf(ErrorCanceled, "%s (canceled)", msg)
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()):
+		return Errorf(ErrorTimeout, "%s (timeout, see \"--timeout\" and \"--request-timeout\")", msg)
+	default:
+		return Errorf(ErrorNetwork, "%s", msg)
+	}
+}
+
+//StatusErrorKind returns the error kind for a http status code (empty if the status code has no kind)
+func StatusErrorKind(statusCode int) ErrorKind {
+	switch statusCode {
+	case 401:
+		return ErrorAuth
+	case 403:
+		return ErrorPermission
+	case 404:
+		return ErrorNotFound
+	case 400, 413, 422:
+		return ErrorValidation
+	case 408, 429:
+		return ErrorNetwork
+	}
+	switch {
+	case statusCode >= 500 && statusCode <= 599:
+		return ErrorNetwork
+	default:
+		return ""
+	}
+}
+
+//KindOf returns the kind of an error (empty if the error or none of the errors it wraps has a kind)
+func KindOf(err error) ErrorKind {
+	var perr *Error
+	if errors.As(err, &perr) {
+		return perr.Kind
+	}
+	return ""
+}
@@ -22,10 +22,178 @@ THE SOFTWARE.
 
 package provider
 
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+)
+
+//Target identifies the container repo that gets the README (see ParseTarget)
+type Target struct {
+	//Servername - registry host (with optional port)
+	Servername string
+	//Path - the path components of the repository (namespace and repo name, can be nested)
+	Path []string
+	//Tagname - optional
+	Tagname string
+	//Digest - optional (in form of <algorithm>:<hex>)
+	Digest string
+}
+
+//Namespacename returns all path components except the last one
+func (t Target) Namespacename() string {
+	if len(t.Path) < 2 {
+		return ""
+	}
+	return strings.Join(t.Path[:len(t.Path)-1], "/")
+}
+
+//Reponame returns the last path component
+func (t Target) Reponame() string {
+	if len(t.Path) < 1 {
+		return ""
+	}
+	return t.Path[len(t.Path)-1]
+}
+
+//Repository returns the full repository path
+func (t Target) Repository() string {
+	return strings.Join(t.Path, "/")
+}
+
+//String returns the target in canonical form
+func (t Target) String() string {
+	s := t.Servername + "/" + t.Repository()
+	if t.Tagname != "" {
+		s = s + ":" + t.Tagname
+	}
+	if t.Digest != "" {
+		s = s + "@" + t.Digest
+	}
+	return s
+}
+
+//CredentialType is the kind of a registry login
+type CredentialType string
+
+const (
+	//CredentialBasic - username and password (or access token)
+	CredentialBasic CredentialType = "basic"
+	//CredentialIdentityToken - identity (refresh) token from an OAuth/SSO login
+	CredentialIdentityToken CredentialType = "identitytoken"
+	//CredentialRegistryToken - bearer token
+	CredentialRegistryToken CredentialType = "registrytoken"
+)
+
+//Credentials holds the registry login that was resolved for a target (from env vars or the Docker credentials store)
+type Credentials struct {
+	DockerUser    string
+	DockerPasswd  string
+	IdentityToken string
+	RegistryToken string
+}
+
+//Type returns the kind of the login
+func (c Credentials) Type() CredentialType {
+	switch {
+	case c.RegistryToken != "":
+		return CredentialRegistryToken
+	case c.IdentityToken != "":
+		return CredentialIdentityToken
+	default:
+		return CredentialBasic
+	}
+}
+
+//Token returns the token of a token based login (empty for basic logins)
+func (c Credentials) Token() string {
+	switch c.Type() {
+	case CredentialRegistryToken:
+		return c.RegistryToken
+	case CredentialIdentityToken:
+		return c.IdentityToken
+	default:
+		return ""
+	}
+}
+
+//Authorization returns the value of the Authorization header for the login (basic auth or bearer token).
+//An identity token is a refresh token and can't be sent as it is, for an identity token login the Authorization is empty.
+func (c Credentials) Authorization() string {
+	switch c.Type() {
+	case CredentialRegistryToken:
+		return "Bearer " + c.RegistryToken
+	case CredentialIdentityToken:
+		return ""
+	default:
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(c.DockerUser+":"+c.DockerPasswd))
+	}
+}
+
+//Content holds the repo description that gets pushed
+type Content struct {
+	Readme    string
+	Shortdesc string
+}
+
+//Matches reports whether a remote content already equals the content (an empty short description leaves the remote one untouched and always matches)
+func (c Content) Matches(remote Content) bool {
+	return c.Readme == remote.Readme && (c.Shortdesc == "" || c.Shortdesc == remote.Shortdesc)
+}
+
+//Supported returns the content without the fields that a provider with the given capabilities doesn't support (they get ignored)
+func (c Content) Supported(caps Capabilities) Content {
+	if !caps.ShortDescription {
+		c.Shortdesc = ""
+	}
+	return c
+}
+
+//Action describes what a push did on the repo server
+type Action string
+
+const (
+	//ActionUpdated - the repo description was written
+	ActionUpdated Action = "updated"
+	//ActionUnchanged - the repo description already matched, nothing was written
+	ActionUnchanged Action = "unchanged"
+)
+
+//Result is returned by a push
+type Result struct {
+	Action Action
+	//URL - url of the repo on the repo server (web page if known, otherwise api url)
+	URL string
+}
+
+//Capabilities describes which features a provider supports, so that a request can be checked before anything gets sent
+type Capabilities struct {
+	//ShortDescription - the provider supports a short description in addition to the README
+	ShortDescription bool
+	//TagReadme - the provider supports a README per tag (instead of only per repo)
+	TagReadme bool
+	//ReadBack - the provider reads back the pushed content from the server and validates it
+	ReadBack bool
+	//NestedPaths - the provider supports repository paths with more than two path components (i.e. group/subgroup/repo)
+	NestedPaths bool
+	//MaxReadmeSize - max size of the README in characters (0 = no known limit)
+	MaxReadmeSize int
+}
+
 //Provider interface
 type Provider interface {
 	//GetAuthident - returns the key name under which the provider credentials are stored in Docker's credentials store. Special values: __SERVERNAME__ = use servername, __NONE__ = retrieving credentials will be handled by the provider
 	GetAuthident() (authident string)
-	//Pushrm function - main provider function, performs the api  call to update the repo description
-	Pushrm(servername string, namespacename string, reponame string, tagname string, dockerUser string, dockerPasswd string, readme string, shortdesc string) error
+	//Capabilities - returns the features supported by the provider
+	Capabilities() Capabilities
+	//Pushrm function - main provider function, performs the api call to update the repo description (skipped if the remote content already matches)
+	Pushrm(ctx context.Context, target Target, creds Credentials, content Content) (Result, error)
+	//Pullrm function - performs the api call to read the current repo description
+	Pullrm(ctx context.Context, target Target, creds Credentials) (Content, error)
+}
+
+//TokenValidator is implemented by providers that authenticate with an api key instead of a Docker login (see "docker pushrm login")
+type TokenValidator interface {
+	//ValidateToken - checks an api key against the api of the server, returns the name of the token owner (empty if unknown)
+	ValidateToken(ctx context.Context, servername string, token string) (owner string, err error)
 }
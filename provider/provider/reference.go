@@ -0,0 +1,104 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package provider
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// regular expressions for the image reference grammar of the distribution project
+// (https://github.com/distribution/distribution/blob/main/reference/reference.go)
+var (
+	pathComponentRegexp   = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]+)[a-z0-9]+)*$`)
+	domainComponentRegexp = `(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])`
+	ipv6addressRegexp     = `\[(?:[a-fA-F0-9:]+)\]`
+	domainRegexp          = regexp.MustCompile(`^(?:` + domainComponentRegexp + `(?:\.` + domainComponentRegexp + `)*|` + ipv6addressRegexp + `)(?::[0-9]+)?$`)
+	tagRegexp             = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	digestRegexp          = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,}$`)
+)
+
+// max length of the name part (servername + path) of a reference
+const nameTotalLengthMax = 255
+
+//DefaultServername is used for references without servername
+const DefaultServername = "docker.io"
+
+//ParseTarget parses an image reference in form of [servername/]path[/path...][:tag][@digest]. The servername can have a port and can be a bracketed IPv6 address (i.e. [::1]:5000)
+func ParseTarget(ref string) (target Target, err error) {
+	if ref == "" {
+		return target, errors.New("empty image reference")
+	}
+
+	name := ref
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		target.Digest = name[i+1:]
+		name = name[:i]
+		if !digestRegexp.MatchString(target.Digest) {
+			return Target{}, errors.New("invalid digest format: " + target.Digest)
+		}
+	}
+
+	// a colon after the last slash separates the tag (a colon before it belongs to the servername port)
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		target.Tagname = name[i+1:]
+		name = name[:i]
+		if !tagRegexp.MatchString(target.Tagname) {
+			return Target{}, errors.New("invalid tag format: " + target.Tagname)
+		}
+	}
+
+	if len(name) > nameTotalLengthMax {
+		return Target{}, errors.New("repository name must not be more than 255 characters")
+	}
+
+	components := strings.Split(name, "/")
+
+	// like Docker: the first component is a servername if it looks like a hostname (has a dot, port or IPv6 brackets) or is "localhost"
+	if len(components) > 1 && (strings.ContainsAny(components[0], ".:[") || components[0] == "localhost") {
+		target.Servername = strings.ToLower(components[0])
+		components = components[1:]
+		if !domainRegexp.MatchString(target.Servername) {
+			return Target{}, errors.New("invalid servername: " + target.Servername)
+		}
+	} else {
+		target.Servername = DefaultServername
+	}
+
+	if target.Servername == "index.docker.io" || target.Servername == "registry-1.docker.io" {
+		target.Servername = DefaultServername
+	}
+
+	for _, component := range components {
+		if !pathComponentRegexp.MatchString(component) {
+			if strings.ToLower(component) != component {
+				return Target{}, errors.New("repository name must be lowercase")
+			}
+			return Target{}, errors.New("invalid repository name component: \"" + component + "\"")
+		}
+	}
+	target.Path = components
+
+	return target, nil
+}
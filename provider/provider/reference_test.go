@@ -0,0 +1,95 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package provider
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTarget(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("0123456789abcdef", 4)
+
+	tests := []struct {
+		ref     string
+		want    Target
+		wantErr bool
+	}{
+		{ref: "index.docker.io/my-user/my-repo", want: Target{Servername: "docker.io", Path: []string{"my-user", "my-repo"}}},
+		{ref: "registry-1.docker.io/my-user/my-repo", want: Target{Servername: "docker.io", Path: []string{"my-user", "my-repo"}}},
+		{ref: "localhost/my-repo", want: Target{Servername: "localhost", Path: []string{"my-repo"}}},
+		{ref: "harbor.local:8443/my-project/my-repo:1.2", want: Target{Servername: "harbor.local:8443", Path: []string{"my-project", "my-repo"}, Tagname: "1.2"}},
+		{ref: "registry:5000/my-repo", want: Target{Servername: "registry:5000", Path: []string{"my-repo"}}},
+		{ref: "gitlab.example.com:5050/group/sub/project/image:v2", want: Target{Servername: "gitlab.example.com:5050", Path: []string{"group", "sub", "project", "image"}, Tagname: "v2"}},
+		{ref: "my-user/my-repo@" + digest, want: Target{Servername: "docker.io", Path: []string{"my-user", "my-repo"}, Digest: digest}},
+		{ref: "my-user/my-repo:v1@" + digest, want: Target{Servername: "docker.io", Path: []string{"my-user", "my-repo"}, Tagname: "v1", Digest: digest}},
+		{ref: "Registry.Example.COM/my-user/my-repo", want: Target{Servername: "registry.example.com", Path: []string{"my-user", "my-repo"}}},
+		{ref: "my-user/my-repo:V1.0-RC", want: Target{Servername: "docker.io", Path: []string{"my-user", "my-repo"}, Tagname: "V1.0-RC"}},
+
+		{ref: "", wantErr: true},
+		{ref: "My-User/my-repo", wantErr: true},
+		{ref: "my-user/my-repo@sha256:abc", wantErr: true},
+		{ref: "my-user/my-repo@", wantErr: true},
+		{ref: "my-user/my-repo:", wantErr: true},
+		{ref: "my-user/my-repo:-tag", wantErr: true},
+		{ref: "my-user/my-repo:" + strings.Repeat("t", 129), wantErr: true},
+		{ref: "my-user//my-repo", wantErr: true},
+		{ref: "my-user/-my-repo", wantErr: true},
+		{ref: "my_user/my..repo", wantErr: true},
+		{ref: "-registry.example.com/my-repo", wantErr: true},
+		{ref: "registry.example.com:port/my-repo", wantErr: true},
+		{ref: "registry.example.com/" + strings.Repeat("a", 256), wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTarget(tt.ref)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTarget(%q): got error %v, want error: %v", tt.ref, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseTarget(%q) = %+v, want %+v", tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestParseTargetIPv6(t *testing.T) {
+	for ref, want := range map[string]string{
+		"[::1]:5000/my-user/my-repo:tag": "[::1]:5000",
+		"[fe80::1]/my-repo":              "[fe80::1]",
+		"[2001:DB8::1]:443/my-repo":      "[2001:db8::1]:443",
+	} {
+		got, err := ParseTarget(ref)
+		if err != nil {
+			t.Errorf("ParseTarget(%q): %v", ref, err)
+		} else if got.Servername != want {
+			t.Errorf("ParseTarget(%q): got servername %q, want %q", ref, got.Servername, want)
+		}
+	}
+
+	for _, ref := range []string{"[::1/my-repo", "::1/my-repo", "[::1]x/my-repo"} {
+		if _, err := ParseTarget(ref); err == nil {
+			t.Errorf("ParseTarget(%q): got no error", ref)
+		}
+	}
+}
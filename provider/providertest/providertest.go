@@ -0,0 +1,424 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package providertest
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/christian-korneck/docker-pushrm/provider/provider"
+)
+
+// Request is the kind of a request to the api of a provider
+type Request int
+
+const (
+	//RequestUnknown - the request isn't part of the api (fails the test)
+	RequestUnknown Request = iota
+	//RequestAuth - login (for providers that exchange the credentials for a token first)
+	RequestAuth
+	//RequestRead - reads the repo description
+	RequestRead
+	//RequestWrite - updates the repo description
+	RequestWrite
+)
+
+// Suite pairs a provider with a description of its http api. Run runs the standard scenarios against it,
+// with a scripted backend that speaks this api.
+type Suite struct {
+	Provider provider.Provider
+	Target   provider.Target
+	Creds    provider.Credentials
+	//Setup points the provider to the url of the backend (i.e. sets the api url override). Called for each scenario.
+	Setup func(t *testing.T, url string)
+	//Classify returns the kind of an api request
+	Classify func(r *http.Request) Request
+	//AuthResponse - response body of a successful login (only for providers that send RequestAuth requests)
+	AuthResponse map[string]interface{}
+	//RepoResponse - response body of a successful read or write, with the description that the repo server has stored
+	RepoResponse func(remote provider.Content) map[string]interface{}
+	//ErrorResponse - response body of a failed request
+	ErrorResponse func(statusCode int, message string) map[string]interface{}
+	//DecodeWrite returns the content that a write request pushes (an empty short description means that it wasn't sent)
+	DecodeWrite func(body []byte) (provider.Content, error)
+	//ReadBeforeWrite - the provider has to read the repo before it can write (i.e. to look up the repo), so a failed read fails the push
+	ReadBeforeWrite bool
+}
+
+// conformanceScript controls how the backend responds in a scenario
+type conformanceScript struct {
+	auth     int  // status code of logins (0 = success)
+	read     int  // status code of reads (0 = success)
+	write    int  // status code of writes (0 = success)
+	maxSize  int  // writes with a larger README get rejected with 400 (0 = no limit)
+	tamper   bool // writes store something else than was pushed
+	rawError bool // error responses aren't json (like the error page of a proxy)
+}
+
+// conformanceScenario is a push (or pull) against a backend with a script and the expected outcome
+type conformanceScenario struct {
+	name     string
+	requires func(caps provider.Capabilities) bool // scenario gets skipped for providers without these capabilities
+	script   conformanceScript
+	remote   provider.Content // description on the repo server before the call
+	pull     bool             // pull instead of push
+	content  provider.Content // pushed content
+	wantErr  bool
+	wantKind provider.ErrorKind // kind of the error (checked if wantErr)
+	// expected result of a successful call
+	wantAction  provider.Action
+	wantContent provider.Content  // pulled content
+	wantRemote  *provider.Content // description on the repo server after the call (nil = unchanged)
+	noWrite     bool              // no write request must be sent
+	readFails   bool              // the read fails: providers with ReadBeforeWrite must fail the push without a write
+}
+
+// text with multi byte characters, combining characters, emoji, right to left script and an invisible character
+const conformanceUnicode = "# Grüße 🐳\n\n日本語のテキスト, é, שלום, zero​width\n"
+
+var conformanceScenarios = []conformanceScenario{
+	{
+		name:       "push updates the README",
+		remote:     provider.Content{Readme: "old"},
+		content:    provider.Content{Readme: "# new\n"},
+		wantAction: provider.ActionUpdated,
+		wantRemote: &provider.Content{Readme: "# new\n"},
+	},
+	{
+		name:       "push skips unchanged content",
+		remote:     provider.Content{Readme: "# same\n"},
+		content:    provider.Content{Readme: "# same\n"},
+		wantAction: provider.ActionUnchanged,
+		noWrite:    true,
+	},
+	{
+		name:       "push when reading the current description fails",
+		script:     conformanceScript{read: 500},
+		remote:     provider.Content{Readme: "old"},
+		content:    provider.Content{Readme: "# new\n"},
+		wantAction: provider.ActionUpdated,
+		wantRemote: &provider.Content{Readme: "# new\n"},
+		readFails:  true,
+	},
+	{
+		name:        "pull reads the README",
+		remote:      provider.Content{Readme: "# remote\n"},
+		pull:        true,
+		wantContent: provider.Content{Readme: "# remote\n"},
+	},
+	{
+		name:       "push keeps unicode intact",
+		remote:     provider.Content{Readme: "old"},
+		content:    provider.Content{Readme: conformanceUnicode},
+		wantAction: provider.ActionUpdated,
+		wantRemote: &provider.Content{Readme: conformanceUnicode},
+	},
+	{
+		name:       "push detects unchanged unicode content",
+		remote:     provider.Content{Readme: conformanceUnicode},
+		content:    provider.Content{Readme: conformanceUnicode},
+		wantAction: provider.ActionUnchanged,
+		noWrite:    true,
+	},
+	{
+		name:        "pull keeps unicode intact",
+		remote:      provider.Content{Readme: conformanceUnicode},
+		pull:        true,
+		wantContent: provider.Content{Readme: conformanceUnicode},
+	},
+	{
+		name:     "push with rejected credentials",
+		script:   conformanceScript{auth: 401, read: 401, write: 401},
+		remote:   provider.Content{Readme: "old"},
+		content:  provider.Content{Readme: "# new\n"},
+		wantErr:  true,
+		wantKind: provider.ErrorAuth,
+	},
+	{
+		name:     "pull with rejected credentials",
+		script:   conformanceScript{auth: 401, read: 401, write: 401},
+		remote:   provider.Content{Readme: "old"},
+		pull:     true,
+		wantErr:  true,
+		wantKind: provider.ErrorAuth,
+	},
+	{
+		name:     "push without write permission",
+		script:   conformanceScript{write: 403},
+		remote:   provider.Content{Readme: "old"},
+		content:  provider.Content{Readme: "# new\n"},
+		wantErr:  true,
+		wantKind: provider.ErrorPermission,
+	},
+	{
+		name:     "pull without read permission",
+		script:   conformanceScript{read: 403, write: 403},
+		remote:   provider.Content{Readme: "old"},
+		pull:     true,
+		wantErr:  true,
+		wantKind: provider.ErrorPermission,
+	},
+	{
+		name:     "push to a missing repo",
+		script:   conformanceScript{read: 404, write: 404},
+		content:  provider.Content{Readme: "# new\n"},
+		wantErr:  true,
+		wantKind: provider.ErrorNotFound,
+	},
+	{
+		name:     "pull from a missing repo",
+		script:   conformanceScript{read: 404, write: 404},
+		pull:     true,
+		wantErr:  true,
+		wantKind: provider.ErrorNotFound,
+	},
+	{
+		name:     "push oversized content",
+		script:   conformanceScript{maxSize: 100},
+		remote:   provider.Content{Readme: "old"},
+		content:  provider.Content{Readme: "# large\n" + strings.Repeat("x", 200) + "\n"},
+		wantErr:  true,
+		wantKind: provider.ErrorValidation,
+	},
+	{
+		name:     "push validates the written content",
+		requires: func(caps provider.Capabilities) bool { return caps.ReadBack },
+		script:   conformanceScript{tamper: true},
+		remote:   provider.Content{Readme: "old"},
+		content:  provider.Content{Readme: "# new\n"},
+		wantErr:  true,
+		wantKind: provider.ErrorValidation,
+		// the backend stored the tampered content
+		wantRemote: &provider.Content{Readme: "# new\n (modified)"},
+	},
+	{
+		name:     "push with an error response that isn't json",
+		script:   conformanceScript{write: 502, rawError: true},
+		remote:   provider.Content{Readme: "old"},
+		content:  provider.Content{Readme: "# new\n"},
+		wantErr:  true,
+		wantKind: provider.ErrorNetwork,
+	},
+	{
+		name:       "push ignores an unsupported short description",
+		requires:   func(caps provider.Capabilities) bool { return !caps.ShortDescription },
+		remote:     provider.Content{Readme: "# same\n"},
+		content:    provider.Content{Readme: "# same\n", Shortdesc: "short"},
+		wantAction: provider.ActionUnchanged,
+		noWrite:    true,
+	},
+	{
+		name:       "push updates the short description",
+		requires:   func(caps provider.Capabilities) bool { return caps.ShortDescription },
+		remote:     provider.Content{Readme: "# same\n", Shortdesc: "old"},
+		content:    provider.Content{Readme: "# same\n", Shortdesc: "new"},
+		wantAction: provider.ActionUpdated,
+		wantRemote: &provider.Content{Readme: "# same\n", Shortdesc: "new"},
+	},
+	{
+		name:       "push without short description keeps the remote one",
+		requires:   func(caps provider.Capabilities) bool { return caps.ShortDescription },
+		remote:     provider.Content{Readme: "old", Shortdesc: "keep"},
+		content:    provider.Content{Readme: "# new\n"},
+		wantAction: provider.ActionUpdated,
+		wantRemote: &provider.Content{Readme: "# new\n", Shortdesc: "keep"},
+	},
+}
+
+// Run runs the standard scenarios (auth failures, 403 vs 404, oversized content, unicode, validation after write,
+// ignored fields) against a provider, so that all providers handle them in the same way
+func Run(t *testing.T, suite Suite) {
+	caps := suite.Provider.Capabilities()
+
+	for _, scenario := range conformanceScenarios {
+		scenario := scenario
+		t.Run(scenario.name, func(t *testing.T) {
+			if scenario.requires != nil && !scenario.requires(caps) {
+				t.Skip("not supported by the provider")
+			}
+			if scenario.readFails && suite.ReadBeforeWrite {
+				scenario.wantErr, scenario.wantKind = true, provider.ErrorNetwork
+				scenario.wantRemote, scenario.noWrite = nil, true
+			}
+
+			backend := &conformanceBackend{t: t, suite: suite, script: scenario.script, remote: scenario.remote}
+			server := httptest.NewServer(backend)
+			defer server.Close()
+			suite.Setup(t, server.URL)
+
+			var err error
+			if scenario.pull {
+				var content provider.Content
+				content, err = suite.Provider.Pullrm(context.Background(), suite.Target, suite.Creds)
+				if err == nil && content != scenario.wantContent {
+					t.Errorf("pulled %+v, want %+v", content, scenario.wantContent)
+				}
+			} else {
+				var result provider.Result
+				result, err = suite.Provider.Pushrm(context.Background(), suite.Target, suite.Creds, scenario.content)
+				if err == nil && result.Action != scenario.wantAction {
+					t.Errorf("got action %q, want %q", result.Action, scenario.wantAction)
+				}
+			}
+
+			switch {
+			case scenario.wantErr && err == nil:
+				t.Errorf("got no error, want an error of kind %q", scenario.wantKind)
+			case !scenario.wantErr && err != nil:
+				t.Errorf("got error: %v", err)
+			case scenario.wantErr && provider.KindOf(err) != scenario.wantKind:
+				t.Errorf("got error of kind %q, want kind %q: %v", provider.KindOf(err), scenario.wantKind, err)
+			}
+
+			remote, writes := backend.state()
+			wantRemote := scenario.remote
+			if scenario.wantRemote != nil {
+				wantRemote = *scenario.wantRemote
+			}
+			if remote != wantRemote {
+				t.Errorf("repo server has %+v, want %+v", remote, wantRemote)
+			}
+			if scenario.noWrite && writes > 0 {
+				t.Errorf("got %d write requests, want none", writes)
+			}
+			// the error responses must really be what the scenario claims to test
+			wantContentType := "application/json"
+			if scenario.script.rawError {
+				wantContentType = "text/html"
+			}
+			contentTypes := backend.sentErrorContentTypes()
+			if scenario.script.rawError && len(contentTypes) == 0 {
+				t.Errorf("repo server sent no error response, want one with Content-Type %q", wantContentType)
+			}
+			for _, contentType := range contentTypes {
+				if contentType != wantContentType {
+					t.Errorf("repo server sent an error response with Content-Type %q, want %q", contentType, wantContentType)
+				}
+			}
+		})
+	}
+}
+
+// conformanceBackend is a repo server that responds according to a script
+type conformanceBackend struct {
+	t      *testing.T
+	suite  Suite
+	script conformanceScript
+
+	mu                sync.Mutex
+	remote            provider.Content
+	writes            int
+	errorContentTypes []string // Content-Type headers of the sent error responses
+}
+
+func (b *conformanceBackend) state() (provider.Content, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.remote, b.writes
+}
+
+func (b *conformanceBackend) sentErrorContentTypes() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.errorContentTypes...)
+}
+
+func (b *conformanceBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.suite.Classify(r) {
+	case RequestAuth:
+		if b.script.auth != 0 {
+			b.fail(w, b.script.auth)
+			return
+		}
+		b.respond(w, 200, b.suite.AuthResponse)
+
+	case RequestRead:
+		if b.script.read != 0 {
+			b.fail(w, b.script.read)
+			return
+		}
+		b.respond(w, 200, b.suite.RepoResponse(b.remote))
+
+	case RequestWrite:
+		b.writes++
+		if b.script.write != 0 {
+			b.fail(w, b.script.write)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		content, err := b.suite.DecodeWrite(body)
+		if err != nil {
+			b.t.Errorf("invalid write request: %v", err)
+			b.fail(w, 400)
+			return
+		}
+		if b.script.maxSize > 0 && len(content.Readme) > b.script.maxSize {
+			b.fail(w, 400)
+			return
+		}
+		b.remote.Readme = content.Readme
+		if content.Shortdesc != "" {
+			b.remote.Shortdesc = content.Shortdesc
+		}
+		if b.script.tamper {
+			b.remote.Readme = b.remote.Readme + " (modified)"
+		}
+		b.respond(w, 200, b.suite.RepoResponse(b.remote))
+
+	default:
+		b.t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+		b.fail(w, 500)
+	}
+}
+
+// respond writes a json response. Unknown fields get added, they must be ignored by the provider.
+func (b *conformanceBackend) respond(w http.ResponseWriter, statusCode int, dat map[string]interface{}) {
+	response := map[string]interface{}{"pushrm_unknown_field": map[string]interface{}{"nested": []int{1, 2, 3}}}
+	for key, value := range dat {
+		response[key] = value
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (b *conformanceBackend) fail(w http.ResponseWriter, statusCode int) {
+	if b.script.rawError {
+		w.Header().Set("Content-Type", "text/html")
+		b.errorContentTypes = append(b.errorContentTypes, w.Header().Get("Content-Type"))
+		w.WriteHeader(statusCode)
+		w.Write([]byte("<html><body>" + http.StatusText(statusCode) + "</body></html>"))
+		return
+	}
+	b.respond(w, statusCode, b.suite.ErrorResponse(statusCode, http.StatusText(statusCode)))
+	b.errorContentTypes = append(b.errorContentTypes, w.Header().Get("Content-Type"))
+}
@@ -23,12 +23,14 @@ THE SOFTWARE.
 package quay
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
 
+	"github.com/christian-korneck/docker-pushrm/provider/provider"
 	"github.com/christian-korneck/docker-pushrm/util"
 	log "github.com/sirupsen/logrus"
 )
@@ -38,28 +40,54 @@ type Quay struct {
 }
 
 //Pushrm is the main provider function
-func (f Quay) Pushrm(servername string, namespacename string, reponame string, tagname string, dockerUser string, dockerPasswd string, readme string, shortdesc string) error {
-
-	if shortdesc != "" {
-		log.Warn("Short description not supported for provider \"quay\". Ignoring.")
-	}
+func (f Quay) Pushrm(ctx context.Context, target provider.Target, creds provider.Credentials, content provider.Content) (provider.Result, error) {
 
 	log.Debug("Quay.Pushrm called")
+	content = content.Supported(f.Capabilities())
 
-	apikey, err := util.GetApikey(servername)
+	apikey, err := util.GetApikey(target.Servername)
 	if err != nil {
-		return fmt.Errorf(err.Error())
+		return provider.Result{}, &provider.Error{Kind: provider.ErrorAuth, Err: err}
 	}
 	//log.Debug("apikey: " + apikey)
 	log.Debug("apikey: " + "********")
 
-	err = PatchDescription(apikey, readme, servername, namespacename, reponame)
+	// skip the write if the repo server already has the same content
+	remoteReadme, err := GetDescription(ctx, apikey, target.Servername, target.Namespacename(), target.Reponame())
+	if err != nil {
+		log.Debug("could not fetch current repo description, pushing anyway: ", err)
+	} else if content.Matches(provider.Content{Readme: remoteReadme}) {
+		log.Debug("remote content matches, skipping push")
+		return provider.Result{Action: provider.ActionUnchanged, URL: util.BaseURL(target.Servername) + "/repository/" + target.Repository()}, nil
+	}
+
+	err = PatchDescription(ctx, apikey, content.Readme, target.Servername, target.Namespacename(), target.Reponame())
+	if err != nil {
+		log.Debug(err)
+		return provider.Result{}, fmt.Errorf("error pushing readme to repo server. See error message below. Run with \"--debug\" for more details. \n\n%w", err)
+	}
+
+	return provider.Result{Action: provider.ActionUpdated, URL: util.BaseURL(target.Servername) + "/repository/" + target.Repository()}, nil
+}
+
+//Pullrm reads the current repo description
+func (f Quay) Pullrm(ctx context.Context, target provider.Target, creds provider.Credentials) (provider.Content, error) {
+
+	log.Debug("Quay.Pullrm called")
+
+	apikey, err := util.GetApikey(target.Servername)
+	if err != nil {
+		return provider.Content{}, &provider.Error{Kind: provider.ErrorAuth, Err: err}
+	}
+	log.Debug("apikey: " + "********")
+
+	readme, err := GetDescription(ctx, apikey, target.Servername, target.Namespacename(), target.Reponame())
 	if err != nil {
 		log.Debug(err)
-		return fmt.Errorf("error pushing readme to repo server. See error message below. Run with \"--debug\" for more details. \n\n" + err.Error())
+		return provider.Content{}, fmt.Errorf("error fetching readme from repo server. See error message below. Run with \"--debug\" for more details. \n\n%w", err)
 	}
 
-	return nil
+	return provider.Content{Readme: readme}, nil
 }
 
 //GetAuthident returns authident for local Docker credentials store
@@ -69,17 +97,92 @@ func (f Quay) GetAuthident() (authident string) {
 	return
 }
 
+//ValidateToken checks a Quay api key (OAuth access token) by reading the user that it belongs to
+func (f Quay) ValidateToken(ctx context.Context, servername string, token string) (owner string, error error) {
+
+	log.Debug("Quay.ValidateToken called")
+
+	apiurl := util.APIBaseURL("quay", util.BaseURL(servername)) + "/api/v1/user/"
+
+	client, err := util.NewHTTPClient(servername)
+	if err != nil {
+		log.Debug(err)
+		return "", fmt.Errorf("error validating api key, error creating http client: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", apiurl, nil)
+	if err != nil {
+		log.Debug(err)
+		return "", fmt.Errorf("error validating api key, error creating http request")
+	}
+
+	req.Header.Add("Authorization", "Bearer "+token)
+
+	res, err := client.Do(req)
+	if err != nil {
+		log.Debug(err)
+		return "", provider.RequestError(err, "error validating api key, error making http request")
+	}
+
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		log.Debug(err)
+		return "", provider.RequestError(err, "error validating api key, error reading response body")
+	}
+
+	var dat map[string]interface{}
+	if err := json.Unmarshal(body, &dat); err != nil {
+		log.Debug(err)
+	}
+
+	log.Debug("validate api key, status code: ", res.StatusCode)
+
+	// a token without the scope "user:read" can't read the user, but it was accepted
+	if res.StatusCode == 403 {
+		log.Debug("api key is valid, but can't read user info")
+		return "", nil
+	}
+
+	if res.StatusCode != 200 {
+		msg := "error validating api key, bad status code for response: " + res.Status
+		if errmsg, ok := dat["error_message"].(string); ok {
+			msg = msg + ". Server responded: \"" + errmsg + "\""
+		}
+		return "", provider.StatusError(res.StatusCode, msg)
+	}
+
+	owner, _ = dat["username"].(string)
+
+	return owner, nil
+}
+
+//Capabilities returns the features supported by Quay
+func (f Quay) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		ShortDescription: false,
+		TagReadme:        false,
+		ReadBack:         false,
+		NestedPaths:      false,
+		// Quay stores the repo description in a text field without a length limit
+		MaxReadmeSize: 0,
+	}
+}
+
 //PatchDescription - api call to update the repo description
-func PatchDescription(quaytoken string, readme string, servername string, namespacename string, reponame string) (error error) {
+func PatchDescription(ctx context.Context, quaytoken string, readme string, servername string, namespacename string, reponame string) (error error) {
 
-	apiurl := "https://" + servername + "/api/v1/repository/" + namespacename + "/" + reponame
+	apiurl := util.APIBaseURL("quay", util.BaseURL(servername)) + "/api/v1/repository/" + namespacename + "/" + reponame
 	method := "PUT"
 
 	jsonbody, _ := json.Marshal(map[string]string{"description": readme})
 	payload := strings.NewReader(string(jsonbody))
 
-	client := &http.Client{}
-	req, err := http.NewRequest(method, apiurl, payload)
+	client, err := util.NewHTTPClient(servername)
+	if err != nil {
+		log.Debug(err)
+		return fmt.Errorf("error pushing README, error creating http client: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, apiurl, payload)
 	if err != nil {
 		log.Debug(err)
 		return fmt.Errorf("error pushing README, error creating http request")
@@ -91,14 +194,14 @@ func PatchDescription(quaytoken string, readme string, servername string, namesp
 	res, err := client.Do(req)
 	if err != nil {
 		log.Debug(err)
-		return fmt.Errorf("error pushing README, error creating http request")
+		return provider.RequestError(err, "error pushing README, error creating http request")
 	}
 
 	defer res.Body.Close()
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		log.Debug(err)
-		return fmt.Errorf("error pushing README, error reading response body")
+		return provider.RequestError(err, "error pushing README, error reading response body")
 	}
 
 	log.Debug("push readme, response body: " + string(body))
@@ -115,15 +218,14 @@ func PatchDescription(quaytoken string, readme string, servername string, namesp
 
 	if res.StatusCode != 200 {
 		msg := "error pushing README, bad status code for response: " + res.Status
-		if dat["error_message"] != nil {
-
-			msg = msg + ". Server responded: \"" + dat["error_message"].(string) + "\""
+		if errmsg, ok := dat["error_message"].(string); ok {
+			msg = msg + ". Server responded: \"" + errmsg + "\""
 		}
 		if res.StatusCode == 403 {
 			msg = msg + ". Try \"docker logout\" and \"docker login\""
 
 		}
-		return fmt.Errorf(msg)
+		return provider.StatusError(res.StatusCode, msg)
 
 	} else {
 		log.Debug("status code OK, readme successfully pushed to repo server")
@@ -131,3 +233,55 @@ func PatchDescription(quaytoken string, readme string, servername string, namesp
 	}
 
 }
+
+//GetDescription - api call to read the repo description
+func GetDescription(ctx context.Context, quaytoken string, servername string, namespacename string, reponame string) (readme string, error error) {
+
+	apiurl := util.APIBaseURL("quay", util.BaseURL(servername)) + "/api/v1/repository/" + namespacename + "/" + reponame
+	method := "GET"
+
+	client, err := util.NewHTTPClient(servername)
+	if err != nil {
+		log.Debug(err)
+		return "", fmt.Errorf("error fetching README, error creating http client: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, apiurl, nil)
+	if err != nil {
+		log.Debug(err)
+		return "", fmt.Errorf("error fetching README, error creating http request")
+	}
+
+	req.Header.Add("Authorization", "Bearer "+quaytoken)
+
+	res, err := client.Do(req)
+	if err != nil {
+		log.Debug(err)
+		return "", provider.RequestError(err, "error fetching README, error making http request")
+	}
+
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		log.Debug(err)
+		return "", provider.RequestError(err, "error fetching README, error reading response body")
+	}
+
+	var dat map[string]interface{}
+	if err := json.Unmarshal(body, &dat); err != nil {
+		log.Debug(err)
+	}
+
+	log.Debug("fetch README, status code: ", res.StatusCode)
+
+	if res.StatusCode != 200 {
+		msg := "error fetching README, bad status code for response: " + res.Status
+		if errmsg, ok := dat["error_message"].(string); ok {
+			msg = msg + ". Server responded: \"" + errmsg + "\""
+		}
+		return "", provider.StatusError(res.StatusCode, msg)
+	}
+
+	readme, _ = dat["description"].(string)
+
+	return readme, nil
+}
@@ -0,0 +1,83 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package quay
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/christian-korneck/docker-pushrm/provider/provider"
+	"github.com/christian-korneck/docker-pushrm/provider/providertest"
+	"github.com/spf13/viper"
+)
+
+func TestConformance(t *testing.T) {
+	providertest.Run(t, providertest.Suite{
+		Provider: Quay{},
+		Target:   provider.Target{Servername: "quay.io", Path: []string{"my-org", "my-repo"}},
+		Setup: func(t *testing.T, url string) {
+			viper.Set("quay-url", url)
+			old, existed := os.LookupEnv("DOCKER_APIKEY")
+			os.Setenv("DOCKER_APIKEY", "my-apikey")
+			t.Cleanup(func() {
+				viper.Set("quay-url", "")
+				if existed {
+					os.Setenv("DOCKER_APIKEY", old)
+				} else {
+					os.Unsetenv("DOCKER_APIKEY")
+				}
+			})
+		},
+		Classify: func(r *http.Request) providertest.Request {
+			switch {
+			case r.URL.Path != "/api/v1/repository/my-org/my-repo" || r.Header.Get("Authorization") != "Bearer my-apikey":
+				return providertest.RequestUnknown
+			case r.Method == "GET":
+				return providertest.RequestRead
+			case r.Method == "PUT":
+				return providertest.RequestWrite
+			}
+			return providertest.RequestUnknown
+		},
+		RepoResponse: func(remote provider.Content) map[string]interface{} {
+			return map[string]interface{}{"namespace": "my-org", "name": "my-repo", "description": remote.Readme, "is_public": true}
+		},
+		ErrorResponse: func(statusCode int, message string) map[string]interface{} {
+			return map[string]interface{}{"status": statusCode, "error_message": message, "title": "error"}
+		},
+		DecodeWrite: func(body []byte) (provider.Content, error) {
+			var dat map[string]interface{}
+			if err := json.Unmarshal(body, &dat); err != nil {
+				return provider.Content{}, err
+			}
+			readme, ok := dat["description"].(string)
+			if !ok || len(dat) != 1 {
+				return provider.Content{}, errors.New("expected only the field description")
+			}
+			return provider.Content{Readme: readme}, nil
+		},
+	})
+}
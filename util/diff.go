@@ -0,0 +1,211 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// number of unchanged lines shown around a change
+const diffContext = 3
+
+// changed blocks with more lines than this (on either side) are not diffed line by line,
+// the lcs table grows with the product of both line counts
+const diffMaxLines = 2000
+
+type diffOp struct {
+	kind byte // ' ' (unchanged), '-' (removed), '+' (added)
+	line string
+	aPos int // number of lines of a before this op
+	bPos int // number of lines of b before this op
+}
+
+// UnifiedDiff returns a line based diff from a to b in unified format (empty if a and b are equal)
+func UnifiedDiff(a string, b string, aName string, bName string) string {
+	if a == b {
+		return ""
+	}
+
+	// lines keep their trailing newline, so that a missing newline at the end is a difference too
+	aLines := splitLines(a)
+	bLines := splitLines(b)
+	ops := diffLines(aLines, bLines)
+
+	var sb strings.Builder
+	sb.WriteString("--- " + aName + "\n")
+	sb.WriteString("+++ " + bName + "\n")
+
+	for start := 0; start < len(ops); {
+		// find next change
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+
+		// extend hunk as long as changes are close to each other
+		last := first
+		for i := first; i < len(ops); i++ {
+			if ops[i].kind != ' ' {
+				last = i
+			} else if i-last > 2*diffContext {
+				break
+			}
+		}
+
+		hunkStart := first - diffContext
+		if hunkStart < start {
+			hunkStart = start
+		}
+		hunkEnd := last + diffContext + 1
+		if hunkEnd > len(ops) {
+			hunkEnd = len(ops)
+		}
+
+		aCount, bCount := 0, 0
+		for _, op := range ops[hunkStart:hunkEnd] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		aStart, bStart := ops[hunkStart].aPos, ops[hunkStart].bPos
+		if aCount > 0 {
+			aStart++
+		}
+		if bCount > 0 {
+			bStart++
+		}
+		sb.WriteString(fmt.Sprintf("@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount)))
+
+		for _, op := range ops[hunkStart:hunkEnd] {
+			sb.WriteString(string(op.kind) + op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		start = hunkEnd
+	}
+
+	return sb.String()
+}
+
+func hunkRange(start int, count int) string {
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// splitLines splits a text into lines (including the trailing newline of each line)
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes a minimal edit script from a to b (longest common subsequence)
+func diffLines(a []string, b []string) []diffOp {
+	var ops []diffOp
+
+	// common prefix and suffix don't need to go through the lcs table
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, diffOp{kind: ' ', line: a[i], aPos: i, bPos: i})
+	}
+
+	am := a[prefix : len(a)-suffix]
+	bm := b[prefix : len(b)-suffix]
+
+	if len(am) > diffMaxLines || len(bm) > diffMaxLines {
+		// too large, show the whole block as removed and added
+		for i := range am {
+			ops = append(ops, diffOp{kind: '-', line: am[i], aPos: prefix + i, bPos: prefix})
+		}
+		for j := range bm {
+			ops = append(ops, diffOp{kind: '+', line: bm[j], aPos: len(a) - suffix, bPos: prefix + j})
+		}
+		return appendSuffix(ops, a, b, suffix)
+	}
+
+	// lcs[i][j] = length of the longest common subsequence of am[i:] and bm[j:]
+	lcs := make([][]int, len(am)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bm)+1)
+	}
+	for i := len(am) - 1; i >= 0; i-- {
+		for j := len(bm) - 1; j >= 0; j-- {
+			if am[i] == bm[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(am) || j < len(bm) {
+		switch {
+		case i < len(am) && j < len(bm) && am[i] == bm[j]:
+			ops = append(ops, diffOp{kind: ' ', line: am[i], aPos: prefix + i, bPos: prefix + j})
+			i++
+			j++
+		case j == len(bm) || (i < len(am) && lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{kind: '-', line: am[i], aPos: prefix + i, bPos: prefix + j})
+			i++
+		default:
+			ops = append(ops, diffOp{kind: '+', line: bm[j], aPos: prefix + i, bPos: prefix + j})
+			j++
+		}
+	}
+
+	return appendSuffix(ops, a, b, suffix)
+}
+
+// appendSuffix appends the common suffix of a and b as unchanged lines
+func appendSuffix(ops []diffOp, a []string, b []string, suffix int) []diffOp {
+	for k := 0; k < suffix; k++ {
+		ai := len(a) - suffix + k
+		bi := len(b) - suffix + k
+		ops = append(ops, diffOp{kind: ' ', line: a[ai], aPos: ai, bPos: bi})
+	}
+	return ops
+}
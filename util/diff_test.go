@@ -0,0 +1,106 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package util
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// numberedLines returns the lines "1\n" to "<n>\n", with replacements for single lines
+func numberedLines(n int, replace map[int]string) string {
+	var sb strings.Builder
+	for i := 1; i <= n; i++ {
+		if line, ok := replace[i]; ok {
+			sb.WriteString(line + "\n")
+		} else {
+			sb.WriteString(fmt.Sprintf("%d\n", i))
+		}
+	}
+	return sb.String()
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want string
+	}{
+		{name: "equal", a: "a\nb\n", b: "a\nb\n", want: ""},
+		{
+			name: "changes within twice the context are merged into one hunk",
+			a:    numberedLines(20, nil),
+			b:    numberedLines(20, map[int]string{5: "five", 12: "twelve"}),
+			want: "@@ -2,14 +2,14 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n 9\n 10\n 11\n-12\n+twelve\n 13\n 14\n 15\n",
+		},
+		{
+			name: "changes further apart get separate hunks",
+			a:    numberedLines(20, nil),
+			b:    numberedLines(20, map[int]string{5: "five", 13: "thirteen"}),
+			want: "@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n" +
+				"@@ -10,7 +10,7 @@\n 10\n 11\n 12\n-13\n+thirteen\n 14\n 15\n 16\n",
+		},
+		{name: "added to empty", a: "", b: "x\ny\n", want: "@@ -0,0 +1,2 @@\n+x\n+y\n"},
+		{name: "removed everything", a: "x\ny\n", b: "", want: "@@ -1,2 +0,0 @@\n-x\n-y\n"},
+		{name: "single line hunk", a: "x\n", b: "y\n", want: "@@ -1 +1 @@\n-x\n+y\n"},
+		{
+			name: "newline added at end of file",
+			a:    "a\nb",
+			b:    "a\nb\n",
+			want: "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{
+			name: "newline removed at end of file",
+			a:    "a\nb\n",
+			b:    "a\nb",
+			want: "@@ -1,2 +1,2 @@\n a\n-b\n+b\n\\ No newline at end of file\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want
+			if want != "" {
+				want = "--- A\n+++ B\n" + want
+			}
+			if got := UnifiedDiff(tt.a, tt.b, "A", "B"); got != want {
+				t.Errorf("got\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiffMaxLines(t *testing.T) {
+	a := "head\n" + strings.Repeat("a\n", diffMaxLines+1) + "tail\n"
+	b := "head\n" + strings.Repeat("b\n", diffMaxLines+1) + "tail\n"
+
+	got := UnifiedDiff(a, b, "A", "B")
+	header := fmt.Sprintf("--- A\n+++ B\n@@ -1,%d +1,%d @@\n head\n-a\n", diffMaxLines+3, diffMaxLines+3)
+	if !strings.HasPrefix(got, header) {
+		t.Fatalf("got header %q, want %q", got[:len(header)], header)
+	}
+	if !strings.HasSuffix(got, "+b\n tail\n") {
+		t.Errorf("got end %q, want %q", got[len(got)-20:], "+b\n tail\n")
+	}
+}
@@ -0,0 +1,198 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	homedir "github.com/mitchellh/go-homedir"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// the warning about disabled cert verification is only shown once (and not for every api call)
+var insecureWarning sync.Once
+
+//NewHTTPClient returns the http client that providers use for api calls to a server (host with optional port).
+//It trusts the CA certs from "--tlscacert" and uses the client cert from "--tlscert"/"--tlskey". Additionally
+//CA and client certs are loaded from Docker's certs.d/<servername>/ directories (same layout as for the Docker daemon).
+//Requests go through the proxy from "--proxy", the env vars HTTPS_PROXY/HTTP_PROXY/NO_PROXY or the "proxies" section
+//of the Docker config file. Transient failures are retried (see "--retries" and "--retry-max-wait").
+//For insecure registries (see InsecureRegistries) certs aren't verified and requests fall back to plain http.
+func NewHTTPClient(servername string) (*http.Client, error) {
+	tlsConfig, err := newTLSConfig(servername)
+	if err != nil {
+		return nil, err
+	}
+	insecure := IsInsecureRegistry(servername)
+	if insecure {
+		log.Debug(servername, " is an insecure registry, TLS certificates are not verified")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	proxies := getProxySettings()
+	if err := proxies.validate(); err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.Proxy = proxies.proxyFunc()
+
+	if insecure {
+		return &http.Client{Transport: newRetryTransport(&plainHTTPFallback{next: transport})}, nil
+	}
+	return &http.Client{Transport: newRetryTransport(transport)}, nil
+}
+
+func newTLSConfig(servername string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	// "--tlsverify=false" (global flag of the Docker CLI) only counts if it's set explicitly, the default is true
+	if viper.GetBool("insecure-skip-verify") || (viper.IsSet("tlsverify") && !viper.GetBool("tlsverify")) {
+		insecureWarning.Do(func() {
+			log.Warn("TLS certificate verification is disabled (\"--insecure-skip-verify\" or \"--tlsverify=false\")")
+		})
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	var caFiles []string
+	var certFiles [][2]string
+
+	if cafile := viper.GetString("tlscacert"); cafile != "" {
+		caFiles = append(caFiles, cafile)
+	}
+	certfile, keyfile := viper.GetString("tlscert"), viper.GetString("tlskey")
+	if (certfile == "") != (keyfile == "") {
+		return nil, fmt.Errorf("\"--tlscert\" and \"--tlskey\" need to be specified together")
+	}
+	if certfile != "" {
+		certFiles = append(certFiles, [2]string{certfile, keyfile})
+	}
+
+	for _, dir := range certsDirs(servername) {
+		dirCAFiles, dirCertFiles, err := readCertsDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		caFiles = append(caFiles, dirCAFiles...)
+		certFiles = append(certFiles, dirCertFiles...)
+	}
+
+	if len(caFiles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			log.Debug("could not load system cert pool: ", err)
+			pool = x509.NewCertPool()
+		}
+		for _, cafile := range caFiles {
+			pem, err := ioutil.ReadFile(cafile)
+			if err != nil {
+				log.Debug(err)
+				return nil, fmt.Errorf("could not read CA cert file: %s", cafile)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("could not parse CA cert file (PEM expected): %s", cafile)
+			}
+			log.Debug("using CA cert file: ", cafile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	for _, pair := range certFiles {
+		cert, err := tls.LoadX509KeyPair(pair[0], pair[1])
+		if err != nil {
+			log.Debug(err)
+			return nil, fmt.Errorf("could not load client cert %s with key %s: %w", pair[0], pair[1], err)
+		}
+		log.Debug("using client cert file: ", pair[0])
+		tlsConfig.Certificates = append(tlsConfig.Certificates, cert)
+	}
+
+	return tlsConfig, nil
+}
+
+// certsDirs returns the existing Docker certs.d directories for a servername
+func certsDirs(servername string) (dirs []string) {
+	var bases []string
+	if home, err := homedir.Dir(); err == nil {
+		bases = append(bases, filepath.Join(home, ".docker", "certs.d"))
+	}
+	if runtime.GOOS == "windows" {
+		bases = append(bases, filepath.Join(os.Getenv("ProgramData"), "docker", "certs.d"))
+	} else {
+		bases = append(bases, "/etc/docker/certs.d")
+	}
+
+	for _, base := range bases {
+		dir := filepath.Join(base, servername)
+		if runtime.GOOS == "windows" {
+			// colons are not allowed in windows paths, Docker uses "host_port" instead
+			dir = filepath.Join(base, strings.Replace(servername, ":", "_", -1))
+		}
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// readCertsDir reads a certs.d/<servername>/ directory: *.crt are CA certs, *.cert are client certs with a matching *.key
+func readCertsDir(dir string) (caFiles []string, certFiles [][2]string, error error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Debug(err)
+		return nil, nil, fmt.Errorf("could not read certs directory: %s", dir)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(dir, name)
+		switch {
+		case strings.HasSuffix(name, ".crt"):
+			caFiles = append(caFiles, path)
+		case strings.HasSuffix(name, ".cert"):
+			keyfile := strings.TrimSuffix(path, ".cert") + ".key"
+			if _, err := os.Stat(keyfile); err != nil {
+				return nil, nil, fmt.Errorf("missing key %s for client cert %s", keyfile, path)
+			}
+			certFiles = append(certFiles, [2]string{path, keyfile})
+		case strings.HasSuffix(name, ".key"):
+			certfile := strings.TrimSuffix(path, ".key") + ".cert"
+			if _, err := os.Stat(certfile); err != nil {
+				return nil, nil, fmt.Errorf("missing client cert %s for key %s", certfile, path)
+			}
+		}
+	}
+
+	return caFiles, certFiles, nil
+}
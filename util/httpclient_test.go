@@ -0,0 +1,280 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/viper"
+)
+
+func TestNewTLSConfigVerify(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		want     bool // InsecureSkipVerify
+	}{
+		{name: "default", settings: map[string]interface{}{}, want: false},
+		{name: "tlsverify", settings: map[string]interface{}{"tlsverify": true}, want: false},
+		{name: "tlsverify=false", settings: map[string]interface{}{"tlsverify": false}, want: true},
+		{name: "insecure-skip-verify", settings: map[string]interface{}{"insecure-skip-verify": true}, want: true},
+		{name: "reset settings", settings: map[string]interface{}{}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.settings {
+				viper.Set(key, value)
+			}
+			defer func() {
+				for key := range tt.settings {
+					viper.Set(key, nil)
+				}
+			}()
+
+			tlsConfig, err := newTLSConfig("registry.example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tlsConfig.InsecureSkipVerify != tt.want {
+				t.Errorf("got InsecureSkipVerify %v, want %v", tlsConfig.InsecureSkipVerify, tt.want)
+			}
+		})
+	}
+}
+
+// testCert is a self-signed cert for 127.0.0.1 that can be used as CA, server and client cert
+type testCert struct {
+	certPEM []byte
+	keyPEM  []byte
+	cert    *x509.Certificate
+}
+
+func newTestCert(t *testing.T, name string) testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testCert{
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+		cert:    cert,
+	}
+}
+
+// newClientCertServer starts a TLS server that requires a client cert signed by clientCA
+func newClientCertServer(t *testing.T, clientCA testCert) *httptest.Server {
+	t.Helper()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	pool := x509.NewCertPool()
+	pool.AddCert(clientCA.cert)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	// failed handshakes are expected in the tests
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// serverCertPEM returns the cert of a httptest TLS server
+func serverCertPEM(server *httptest.Server) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+}
+
+// get makes a request with a tls config
+func get(tlsConfig *tls.Config, url string) error {
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func writeTestFile(t *testing.T, path string, content []byte) string {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNewTLSConfigFlags(t *testing.T) {
+	client := newTestCert(t, "client")
+	other := newTestCert(t, "other")
+	server := newClientCertServer(t, client)
+	servername := server.Listener.Addr().String()
+
+	dir := t.TempDir()
+	cafile := writeTestFile(t, filepath.Join(dir, "ca.pem"), serverCertPEM(server))
+	certfile := writeTestFile(t, filepath.Join(dir, "client.cert"), client.certPEM)
+	keyfile := writeTestFile(t, filepath.Join(dir, "client.key"), client.keyPEM)
+	otherKeyfile := writeTestFile(t, filepath.Join(dir, "other.key"), other.keyPEM)
+
+	tests := []struct {
+		name       string
+		settings   map[string]interface{}
+		wantErr    bool // newTLSConfig fails
+		wantReqErr bool // the request fails
+	}{
+		{name: "server cert not trusted", settings: map[string]interface{}{"tlscert": certfile, "tlskey": keyfile}, wantReqErr: true},
+		{name: "no client cert", settings: map[string]interface{}{"tlscacert": cafile}, wantReqErr: true},
+		{name: "CA and client cert", settings: map[string]interface{}{"tlscacert": cafile, "tlscert": certfile, "tlskey": keyfile}},
+		{name: "client cert without key", settings: map[string]interface{}{"tlscacert": cafile, "tlscert": certfile}, wantErr: true},
+		{name: "mismatched key", settings: map[string]interface{}{"tlscacert": cafile, "tlscert": certfile, "tlskey": otherKeyfile}, wantErr: true},
+		{name: "CA file isn't PEM", settings: map[string]interface{}{"tlscacert": otherKeyfile}, wantErr: true},
+		{name: "missing CA file", settings: map[string]interface{}{"tlscacert": filepath.Join(dir, "missing.pem")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.settings {
+				viper.Set(key, value)
+			}
+			defer func() {
+				for key := range tt.settings {
+					viper.Set(key, nil)
+				}
+			}()
+
+			tlsConfig, err := newTLSConfig(servername)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			err = get(tlsConfig, server.URL)
+			if (err != nil) != tt.wantReqErr {
+				t.Errorf("got request error %v, want error %v", err, tt.wantReqErr)
+			}
+		})
+	}
+}
+
+func TestNewTLSConfigCertsDir(t *testing.T) {
+	client := newTestCert(t, "client")
+	other := newTestCert(t, "other")
+	server := newClientCertServer(t, client)
+	servername := server.Listener.Addr().String() // 127.0.0.1:<port>
+
+	home := t.TempDir()
+	oldHome, oldDisableCache := os.Getenv("HOME"), homedir.DisableCache
+	os.Setenv("HOME", home)
+	homedir.DisableCache = true
+	defer func() {
+		os.Setenv("HOME", oldHome)
+		homedir.DisableCache = oldDisableCache
+	}()
+	certsDir := filepath.Join(home, ".docker", "certs.d", servername)
+
+	// without certs.d directory the server cert isn't trusted
+	tlsConfig, err := newTLSConfig(servername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := get(tlsConfig, server.URL); err == nil {
+		t.Error("got no request error without certs.d directory")
+	}
+
+	// CA cert only: the server cert is trusted, but the server requires a client cert
+	writeTestFile(t, filepath.Join(certsDir, "ca.crt"), serverCertPEM(server))
+	tlsConfig, err = newTLSConfig(servername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.RootCAs == nil || len(tlsConfig.Certificates) != 0 {
+		t.Fatalf("got %d client certs and RootCAs %v, want only RootCAs", len(tlsConfig.Certificates), tlsConfig.RootCAs != nil)
+	}
+	if err := get(tlsConfig, server.URL); err == nil {
+		t.Error("got no request error without client cert")
+	}
+
+	// CA cert and client cert
+	writeTestFile(t, filepath.Join(certsDir, "client.cert"), client.certPEM)
+	writeTestFile(t, filepath.Join(certsDir, "client.key"), client.keyPEM)
+	tlsConfig, err = newTLSConfig(servername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := get(tlsConfig, server.URL); err != nil {
+		t.Errorf("got request error: %v", err)
+	}
+
+	// the client cert doesn't match the key
+	writeTestFile(t, filepath.Join(certsDir, "client.key"), other.keyPEM)
+	if _, err := newTLSConfig(servername); err == nil {
+		t.Error("got no error for a client key that doesn't match the cert")
+	}
+
+	// client cert without key
+	os.Remove(filepath.Join(certsDir, "client.key"))
+	if _, err := newTLSConfig(servername); err == nil {
+		t.Error("got no error for a client cert without key")
+	}
+}
@@ -0,0 +1,249 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	homedir "github.com/mitchellh/go-homedir"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// hosts of insecure registries that turned out to only speak plain http
+var plainHTTPHosts sync.Map
+
+// insecure registries of the current command (see SetInsecureRegistries) and the results per servername
+var insecureRegistries = struct {
+	sync.Mutex
+	entries []string
+	loaded  bool
+	results map[string]bool
+}{results: make(map[string]bool)}
+
+//InsecureRegistries returns the registries that may be reached without TLS verification or with plain http:
+//"--insecure-registry" (env var PUSHRM_INSECURE_REGISTRY), "plugins.docker-pushrm.insecure-registries" in the Docker
+//config file and "insecure-registries" in the daemon.json of a local Docker daemon. Entries are servernames (host with
+//optional port) or CIDR ranges. Unlike for the Docker daemon, registries on loopback addresses need to be listed too
+//(the plain http fallback would send credentials unencrypted).
+func InsecureRegistries() (registries []string) {
+	var entries []string
+	for _, value := range viper.GetStringSlice("insecure-registry") {
+		entries = append(entries, strings.Split(value, ",")...)
+	}
+	entries = append(entries, viper.GetStringSlice("plugins.docker-pushrm.insecure-registries")...)
+	entries = append(entries, daemonInsecureRegistries()...)
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		// Docker doesn't allow a scheme here, we're more forgiving
+		if _, _, err := net.ParseCIDR(entry); err != nil {
+			entry = ConvertToHostname(entry)
+		}
+		if !StringInSlice(entry, registries) {
+			registries = append(registries, entry)
+		}
+	}
+	return registries
+}
+
+//SetInsecureRegistries sets the insecure registries that the api calls of a command use (see InsecureRegistries).
+//Without it, they are read on the first call of IsInsecureRegistry.
+func SetInsecureRegistries(registries []string) {
+	insecureRegistries.Lock()
+	defer insecureRegistries.Unlock()
+	insecureRegistries.entries = registries
+	insecureRegistries.loaded = true
+	insecureRegistries.results = make(map[string]bool)
+}
+
+//IsInsecureRegistry checks if a servername (host with optional port) is an insecure registry. Like for the Docker daemon,
+//servernames match entries with the same host and port, and hosts that resolve to an IP address in a CIDR range.
+//The result is cached per servername.
+func IsInsecureRegistry(servername string) bool {
+	servername = strings.ToLower(servername)
+
+	insecureRegistries.Lock()
+	if !insecureRegistries.loaded {
+		insecureRegistries.entries = InsecureRegistries()
+		insecureRegistries.loaded = true
+	}
+	if insecure, ok := insecureRegistries.results[servername]; ok {
+		insecureRegistries.Unlock()
+		return insecure
+	}
+	entries := insecureRegistries.entries
+	insecureRegistries.Unlock()
+
+	// not locked, hostnames might get resolved
+	insecure := isInsecureRegistry(servername, entries)
+
+	insecureRegistries.Lock()
+	insecureRegistries.results[servername] = insecure
+	insecureRegistries.Unlock()
+	return insecure
+}
+
+// isInsecureRegistry checks if a servername (lowercase) matches one of the insecure registry entries
+func isInsecureRegistry(servername string, entries []string) bool {
+	host := servername
+	if h, _, err := net.SplitHostPort(servername); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+
+	var cidrs []*net.IPNet
+	for _, entry := range entries {
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			cidrs = append(cidrs, cidr)
+			continue
+		}
+		if entry == servername {
+			return true
+		}
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return inCIDRs(ip, cidrs)
+	}
+	if len(cidrs) == 0 {
+		return false
+	}
+
+	// hostnames only get resolved if there are CIDR ranges
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		log.Debug("could not resolve ", host, ": ", err)
+		return false
+	}
+	for _, ip := range ips {
+		if inCIDRs(ip, cidrs) {
+			return true
+		}
+	}
+	return false
+}
+
+func inCIDRs(ip net.IP, cidrs []*net.IPNet) bool {
+	for _, cidr := range cidrs {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// daemonInsecureRegistries reads "insecure-registries" from the daemon.json of a local Docker daemon (if there is one)
+func daemonInsecureRegistries() (registries []string) {
+	var files []string
+	if home, err := homedir.Dir(); err == nil {
+		// Docker Desktop and rootless Docker
+		files = append(files, filepath.Join(home, ".docker", "daemon.json"), filepath.Join(home, ".config", "docker", "daemon.json"))
+	}
+	if runtime.GOOS == "windows" {
+		files = append(files, filepath.Join(os.Getenv("ProgramData"), "docker", "config", "daemon.json"))
+	} else {
+		files = append(files, "/etc/docker/daemon.json")
+	}
+
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var dat struct {
+			InsecureRegistries []string `json:"insecure-registries"`
+		}
+		if err := json.Unmarshal(content, &dat); err != nil {
+			log.Debug("could not parse ", file, ": ", err)
+			continue
+		}
+		log.Debug("insecure registries from ", file, ": ", dat.InsecureRegistries)
+		registries = append(registries, dat.InsecureRegistries...)
+	}
+	return registries
+}
+
+// plainHTTPFallback sends requests to an insecure registry with plain http if https fails (like the Docker daemon does).
+// Once a host answered on plain http, later requests go there right away.
+type plainHTTPFallback struct {
+	next http.RoundTripper
+}
+
+func (t *plainHTTPFallback) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" {
+		return t.next.RoundTrip(req)
+	}
+	if _, ok := plainHTTPHosts.Load(req.URL.Host); ok {
+		return t.next.RoundTrip(plainHTTPRequest(req, req.Body))
+	}
+
+	res, httpsErr := t.next.RoundTrip(req)
+	if httpsErr == nil || errors.Is(httpsErr, context.Canceled) || errors.Is(httpsErr, context.DeadlineExceeded) {
+		return res, httpsErr
+	}
+	if req.Body != nil && req.GetBody == nil {
+		// the body was consumed and can't be sent again
+		return res, httpsErr
+	}
+	log.Debug("https request to insecure registry ", req.URL.Host, " failed (", httpsErr, "), trying plain http")
+
+	body := req.Body
+	if req.GetBody != nil {
+		var err error
+		if body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	res, err := t.next.RoundTrip(plainHTTPRequest(req, body))
+	if err != nil {
+		log.Debug(err)
+		// the https error is more helpful for registries that aren't plain http
+		return nil, httpsErr
+	}
+	log.Warn("using plain http (insecure registry) for ", req.URL.Host, ", credentials are sent unencrypted")
+	plainHTTPHosts.Store(req.URL.Host, true)
+	return res, nil
+}
+
+// plainHTTPRequest returns a copy of an https request with an http url
+func plainHTTPRequest(req *http.Request, body io.ReadCloser) *http.Request {
+	httpReq := req.Clone(req.Context())
+	httpReq.URL.Scheme = "http"
+	httpReq.Body = body
+	return httpReq
+}
@@ -0,0 +1,78 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package util
+
+import "testing"
+
+func TestIsInsecureRegistry(t *testing.T) {
+	tests := []struct {
+		servername string
+		entries    []string
+		want       bool
+	}{
+		{servername: "harbor.local", entries: []string{"harbor.local"}, want: true},
+		{servername: "harbor.local:8080", entries: []string{"harbor.local:8080"}, want: true},
+		// host and port need to match
+		{servername: "harbor.local:8443", entries: []string{"harbor.local:8080"}, want: false},
+		{servername: "harbor.local:8080", entries: []string{"harbor.local"}, want: false},
+		{servername: "[fd00::1]:5000", entries: []string{"[fd00::1]:5000"}, want: true},
+		{servername: "10.1.2.3:5000", entries: []string{"10.0.0.0/8"}, want: true},
+		{servername: "11.1.2.3:5000", entries: []string{"10.0.0.0/8"}, want: false},
+		{servername: "[fd00::1]:5000", entries: []string{"fd00::/8"}, want: true},
+		{servername: "[fe80::1]:5000", entries: []string{"fd00::/8"}, want: false},
+		{servername: "harbor.example.com", entries: nil, want: false},
+		// loopback registries need to be listed too
+		{servername: "localhost:5000", entries: nil, want: false},
+		{servername: "127.0.0.1:5000", entries: nil, want: false},
+		{servername: "[::1]:5000", entries: nil, want: false},
+		{servername: "127.0.0.1:5000", entries: []string{"127.0.0.0/8"}, want: true},
+		{servername: "[::1]:5000", entries: []string{"::1/128"}, want: true},
+	}
+	for _, tt := range tests {
+		if got := isInsecureRegistry(tt.servername, tt.entries); got != tt.want {
+			t.Errorf("isInsecureRegistry(%q, %q) = %v, want %v", tt.servername, tt.entries, got, tt.want)
+		}
+	}
+}
+
+func TestIsInsecureRegistryCached(t *testing.T) {
+	t.Cleanup(func() {
+		SetInsecureRegistries(nil)
+		insecureRegistries.loaded = false
+	})
+
+	SetInsecureRegistries([]string{"harbor.local"})
+	if !IsInsecureRegistry("Harbor.Local") {
+		t.Error("got secure, want insecure")
+	}
+	insecureRegistries.results["quay.local"] = true
+	if !IsInsecureRegistry("quay.local") {
+		t.Error("got secure, want the cached result")
+	}
+
+	// a new command starts without cached results
+	SetInsecureRegistries(nil)
+	if IsInsecureRegistry("harbor.local") || IsInsecureRegistry("quay.local") {
+		t.Error("got insecure, want secure")
+	}
+}
@@ -0,0 +1,254 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package util
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// LinkBase describes where relative link and image targets of a README point to
+type LinkBase struct {
+	Link  string // base url for links (e.g. "https://github.com/my-user/my-repo/blob/<commit>")
+	Image string // base url for images (e.g. "https://github.com/my-user/my-repo/raw/<commit>")
+	Dir   string // directory of the README file below the base urls ("" if the base urls point to the README's directory)
+}
+
+// reference definition: [label]: target "optional title"
+var referenceDefinition = regexp.MustCompile(`^( {0,3}\[)([^\]]+)(\]:[ \t]*)(<[^>]*>|\S+)(.*)$`)
+
+// reference usage by an image: ![alt][label], ![label][] or ![label]
+var imageReference = regexp.MustCompile(`!\[([^\]]*)\](?:\[([^\]]*)\])?`)
+
+// thematic break: ***, --- or ___ (spaces allowed between the characters)
+var thematicBreak = regexp.MustCompile(`^ {0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+
+// url scheme (http:, https:, mailto:, data:, ...)
+var urlScheme = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*:`)
+
+// RewriteRelativeLinks rewrites relative targets of inline and reference-style links and images to absolute urls.
+// Fenced and indented code blocks and code spans are left untouched.
+func RewriteRelativeLinks(markdown string, base LinkBase) string {
+	imageLabels := findImageLabels(markdown)
+
+	lines := strings.SplitAfter(markdown, "\n")
+	var sb strings.Builder
+	var fence string
+	inIndentedCode := false
+	inParagraph := false // the previous line was paragraph text, which an indented line continues
+
+	for _, line := range lines {
+		content := strings.TrimRight(line, "\r\n")
+		eol := line[len(content):]
+		trimmed := strings.TrimLeft(content, " ")
+		indented := len(content)-len(trimmed) >= 4 || strings.HasPrefix(content, "\t")
+		blank := strings.TrimSpace(content) == ""
+		paragraph := false
+
+		switch {
+		case fence != "":
+			// inside a fenced code block
+			if !indented && strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]+" \t") == "" {
+				fence = ""
+			}
+			sb.WriteString(line)
+		case !indented && fenceMarker(trimmed) != "":
+			fence = fenceMarker(trimmed)
+			sb.WriteString(line)
+		case indented && (!inParagraph || inIndentedCode):
+			// indented code block (can't interrupt a paragraph)
+			inIndentedCode = true
+			sb.WriteString(line)
+		case referenceDefinition.MatchString(content):
+			m := referenceDefinition.FindStringSubmatch(content)
+			isImage := imageLabels[normalizeLabel(m[2])]
+			sb.WriteString(m[1] + m[2] + m[3] + rewriteDestination(m[4], isImage, base) + m[5] + eol)
+			paragraph = true
+		default:
+			sb.WriteString(rewriteInline(content, base) + eol)
+			// headings, thematic breaks and setext underlines end a paragraph
+			paragraph = !blank && !atxHeading.MatchString(content) && !thematicBreak.MatchString(content) && !(inParagraph && setextUnderline.MatchString(content))
+		}
+
+		if !blank && !indented {
+			inIndentedCode = false
+		}
+		inParagraph = paragraph
+	}
+
+	return sb.String()
+}
+
+// fenceMarker returns the opening ``` or ~~~ sequence of a code fence line (empty if the line isn't a fence)
+func fenceMarker(line string) string {
+	for _, c := range []string{"`", "~"} {
+		n := len(line) - len(strings.TrimLeft(line, c))
+		if n >= 3 {
+			// the info string of a backtick fence can't contain backticks
+			if c == "`" && strings.Contains(line[n:], "`") {
+				return ""
+			}
+			return line[:n]
+		}
+	}
+	return ""
+}
+
+// findImageLabels returns the (normalized) reference labels that are used by images
+func findImageLabels(markdown string) map[string]bool {
+	labels := make(map[string]bool)
+	for _, m := range imageReference.FindAllStringSubmatch(markdown, -1) {
+		label := m[1]
+		if m[2] != "" {
+			label = m[2]
+		}
+		labels[normalizeLabel(label)] = true
+	}
+	return labels
+}
+
+// normalizeLabel makes reference labels comparable (case-insensitive, collapsed whitespace)
+func normalizeLabel(label string) string {
+	return strings.ToLower(strings.Join(strings.Fields(label), " "))
+}
+
+// rewriteInline rewrites the targets of inline links and images ("[text](target)") of a single line
+func rewriteInline(s string, base LinkBase) string {
+	var sb strings.Builder
+
+	for i := 0; i < len(s); {
+		// skip code spans
+		if s[i] == '`' {
+			n := len(s[i:]) - len(strings.TrimLeft(s[i:], "`"))
+			end := strings.Index(s[i+n:], s[i:i+n])
+			if end < 0 {
+				sb.WriteString(s[i : i+n])
+				i = i + n
+			} else {
+				sb.WriteString(s[i : i+n+end+n])
+				i = i + n + end + n
+			}
+			continue
+		}
+
+		if s[i] == ']' && i+1 < len(s) && s[i+1] == '(' {
+			open := matchingBracket(s, i)
+			isImage := open > 0 && s[open-1] == '!'
+
+			start := i + 2
+			for start < len(s) && (s[start] == ' ' || s[start] == '\t') {
+				start++
+			}
+			end := destinationEnd(s, start)
+			sb.WriteString(s[i:start])
+			sb.WriteString(rewriteDestination(s[start:end], isImage, base))
+			i = end
+			continue
+		}
+
+		sb.WriteByte(s[i])
+		i++
+	}
+
+	return sb.String()
+}
+
+// matchingBracket returns the position of the "[" that belongs to the "]" at position close (-1 if there is none)
+func matchingBracket(s string, close int) int {
+	depth := 0
+	for i := close; i >= 0; i-- {
+		switch s[i] {
+		case ']':
+			depth++
+		case '[':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// destinationEnd returns the end position of a link destination starting at start
+func destinationEnd(s string, start int) int {
+	if start < len(s) && s[start] == '<' {
+		if end := strings.IndexByte(s[start:], '>'); end >= 0 {
+			return start + end + 1
+		}
+		return start
+	}
+
+	// parentheses in a destination need to be balanced
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case ' ', '\t':
+			return i
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return len(s)
+}
+
+// rewriteDestination turns a relative link destination (optionally in <angle brackets>) into an absolute url
+func rewriteDestination(dest string, isImage bool, base LinkBase) string {
+	if strings.HasPrefix(dest, "<") && strings.HasSuffix(dest, ">") {
+		return "<" + resolveTarget(dest[1:len(dest)-1], isImage, base) + ">"
+	}
+	return resolveTarget(dest, isImage, base)
+}
+
+// resolveTarget returns the absolute url for a relative target. Absolute urls and anchors are returned as they are.
+func resolveTarget(target string, isImage bool, base LinkBase) string {
+	if target == "" || strings.HasPrefix(target, "#") || strings.HasPrefix(target, "//") || urlScheme.MatchString(target) {
+		return target
+	}
+
+	p := target
+	suffix := ""
+	if k := strings.IndexAny(p, "?#"); k >= 0 {
+		p, suffix = p[:k], p[k:]
+	}
+
+	if strings.HasPrefix(p, "/") {
+		// relative to the repo root
+		p = path.Clean(p)
+	} else {
+		p = path.Clean("/" + path.Join(base.Dir, p))
+	}
+
+	baseURL := base.Link
+	if isImage {
+		baseURL = base.Image
+	}
+
+	return strings.TrimSuffix(baseURL, "/") + p + suffix
+}
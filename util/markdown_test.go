@@ -0,0 +1,135 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package util
+
+import "testing"
+
+func TestRewriteRelativeLinks(t *testing.T) {
+	base := LinkBase{Link: "https://github.com/my-user/my-repo/blob/abc123", Image: "https://github.com/my-user/my-repo/raw/abc123"}
+	subdir := LinkBase{Link: base.Link, Image: base.Image, Dir: "docs"}
+
+	tests := []struct {
+		name     string
+		base     LinkBase
+		markdown string
+		want     string
+	}{
+		{
+			name:     "inline image",
+			base:     base,
+			markdown: "![arch](docs/arch.png)\n",
+			want:     "![arch](https://github.com/my-user/my-repo/raw/abc123/docs/arch.png)\n",
+		},
+		{
+			name:     "image in a link",
+			base:     base,
+			markdown: "[![logo](logo.png)](docs/)\n",
+			want:     "[![logo](https://github.com/my-user/my-repo/raw/abc123/logo.png)](https://github.com/my-user/my-repo/blob/abc123/docs)\n",
+		},
+		{
+			name:     "title, anchor and query are kept",
+			base:     base,
+			markdown: "[a](a.md#usage \"title\") [b](<b c.md>) [c](c.png?raw=true)\n",
+			want:     "[a](https://github.com/my-user/my-repo/blob/abc123/a.md#usage \"title\") [b](<https://github.com/my-user/my-repo/blob/abc123/b c.md>) [c](https://github.com/my-user/my-repo/blob/abc123/c.png?raw=true)\n",
+		},
+		{
+			name:     "balanced parentheses in the destination",
+			base:     base,
+			markdown: "[a](docs/a_(b).md)\n",
+			want:     "[a](https://github.com/my-user/my-repo/blob/abc123/docs/a_(b).md)\n",
+		},
+		{
+			name:     "absolute urls, anchors and protocol-relative urls are unchanged",
+			base:     base,
+			markdown: "[a](https://example.com/a) [b](#usage) [c](mailto:me@example.com) [d](//cdn.example.com/d.png) ![e](data:image/png;base64,AAAA)\n",
+			want:     "[a](https://example.com/a) [b](#usage) [c](mailto:me@example.com) [d](//cdn.example.com/d.png) ![e](data:image/png;base64,AAAA)\n",
+		},
+		{
+			name:     "relative to the README directory",
+			base:     subdir,
+			markdown: "[a](a.md) [b](../b.md) [c](/c.md) ![d](./img/d.png)\n",
+			want:     "[a](https://github.com/my-user/my-repo/blob/abc123/docs/a.md) [b](https://github.com/my-user/my-repo/blob/abc123/b.md) [c](https://github.com/my-user/my-repo/blob/abc123/c.md) ![d](https://github.com/my-user/my-repo/raw/abc123/docs/img/d.png)\n",
+		},
+		{
+			name:     "reference-style links and images",
+			base:     base,
+			markdown: "[docs][d] ![Arch][arch]\n\n[d]: docs/README.md\n[ARCH]: <arch.png> \"Architecture\"\n[ext]: https://example.com\n",
+			want:     "[docs][d] ![Arch][arch]\n\n[d]: https://github.com/my-user/my-repo/blob/abc123/docs/README.md\n[ARCH]: <https://github.com/my-user/my-repo/raw/abc123/arch.png> \"Architecture\"\n[ext]: https://example.com\n",
+		},
+		{
+			name:     "fenced code blocks are untouched",
+			base:     base,
+			markdown: "```md\n[a](a.md)\n```\n~~~\n![b](b.png)\n~~~\n[c](c.md)\n",
+			want:     "```md\n[a](a.md)\n```\n~~~\n![b](b.png)\n~~~\n[c](https://github.com/my-user/my-repo/blob/abc123/c.md)\n",
+		},
+		{
+			name:     "indented code blocks are untouched",
+			base:     base,
+			markdown: "text\n\n    [a](a.md)\n\n[b](b.md)\n",
+			want:     "text\n\n    [a](a.md)\n\n[b](https://github.com/my-user/my-repo/blob/abc123/b.md)\n",
+		},
+		{
+			name:     "indented code block after a heading is untouched",
+			base:     base,
+			markdown: "## Example\n    docker run -v [x](y.md)\n",
+			want:     "## Example\n    docker run -v [x](y.md)\n",
+		},
+		{
+			name:     "indented code block after a closing fence is untouched",
+			base:     base,
+			markdown: "```\ncode\n```\n    [a](a.md)\n",
+			want:     "```\ncode\n```\n    [a](a.md)\n",
+		},
+		{
+			name:     "indented code block after a thematic break or setext heading is untouched",
+			base:     base,
+			markdown: "***\n    [a](a.md)\n\nTitle\n=====\n    [b](b.md)\n",
+			want:     "***\n    [a](a.md)\n\nTitle\n=====\n    [b](b.md)\n",
+		},
+		{
+			name:     "indented lines in a paragraph aren't code",
+			base:     base,
+			markdown: "text\n    [a](a.md)\n",
+			want:     "text\n    [a](https://github.com/my-user/my-repo/blob/abc123/a.md)\n",
+		},
+		{
+			name:     "code spans are untouched",
+			base:     base,
+			markdown: "`[a](a.md)` and ``[b](b.md)`` but [c](c.md)\n",
+			want:     "`[a](a.md)` and ``[b](b.md)`` but [c](https://github.com/my-user/my-repo/blob/abc123/c.md)\n",
+		},
+		{
+			name:     "windows line endings",
+			base:     base,
+			markdown: "[a](a.md)\r\n",
+			want:     "[a](https://github.com/my-user/my-repo/blob/abc123/a.md)\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RewriteRelativeLinks(tt.markdown, tt.base); got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package util
+
+import (
+	"os"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestBypassProxy(t *testing.T) {
+	tests := []struct {
+		noProxy string
+		host    string
+		port    string
+		want    bool
+	}{
+		{noProxy: "", host: "registry.example.com", port: "443", want: false},
+		{noProxy: "*", host: "registry.example.com", port: "443", want: true},
+		{noProxy: "example.com", host: "example.com", port: "443", want: true},
+		{noProxy: "example.com", host: "registry.example.com", port: "443", want: true},
+		{noProxy: "example.com", host: "notexample.com", port: "443", want: false},
+		// a leading "." or "*." only matches subdomains
+		{noProxy: ".example.com", host: "registry.example.com", port: "443", want: true},
+		{noProxy: ".example.com", host: "example.com", port: "443", want: false},
+		{noProxy: "*.example.com", host: "registry.example.com", port: "443", want: true},
+		{noProxy: "*.example.com", host: "example.com", port: "443", want: false},
+		{noProxy: "Example.COM", host: "registry.example.com", port: "443", want: true},
+		{noProxy: "registry.example.com:5000", host: "registry.example.com", port: "5000", want: true},
+		{noProxy: "registry.example.com:5000", host: "registry.example.com", port: "443", want: false},
+		{noProxy: "10.1.2.3", host: "10.1.2.3", port: "443", want: true},
+		{noProxy: "10.1.2.3:5000", host: "10.1.2.3", port: "443", want: false},
+		{noProxy: "[fd00::1]:5000", host: "fd00::1", port: "5000", want: true},
+		{noProxy: "10.0.0.0/8", host: "10.1.2.3", port: "443", want: true},
+		{noProxy: "10.0.0.0/8", host: "11.1.2.3", port: "443", want: false},
+		// CIDR ranges don't match hostnames (they don't get resolved)
+		{noProxy: "10.0.0.0/8", host: "registry.example.com", port: "443", want: false},
+		{noProxy: "other.example.org, 10.0.0.0/8 ,example.com", host: "registry.example.com", port: "443", want: true},
+	}
+	for _, tt := range tests {
+		if got := bypassProxy(tt.noProxy, tt.host, tt.port); got != tt.want {
+			t.Errorf("bypassProxy(%q, %q, %q) = %v, want %v", tt.noProxy, tt.host, tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestGetProxySettings(t *testing.T) {
+	config := map[string]interface{}{
+		"proxies.default.httpProxy":  "http://config-http:3128",
+		"proxies.default.httpsProxy": "http://config-https:3128",
+		"proxies.default.noProxy":    "config.example.com",
+	}
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		env      map[string]string
+		want     proxySettings
+	}{
+		{
+			name:     "config file",
+			settings: config,
+			want:     proxySettings{httpProxy: "http://config-http:3128", httpsProxy: "http://config-https:3128", noProxy: "config.example.com"},
+		},
+		{
+			name:     "env vars replace all settings of the config file",
+			settings: config,
+			env:      map[string]string{"https_proxy": "http://env:3128"},
+			want:     proxySettings{httpsProxy: "http://env:3128"},
+		},
+		{
+			name:     "a single env var is enough",
+			settings: config,
+			env:      map[string]string{"NO_PROXY": "env.example.com"},
+			want:     proxySettings{noProxy: "env.example.com"},
+		},
+		{
+			name:     "flags replace all env vars",
+			settings: map[string]interface{}{"no-proxy": "flag.example.com"},
+			env:      map[string]string{"HTTPS_PROXY": "http://env:3128", "NO_PROXY": "env.example.com"},
+			want:     proxySettings{noProxy: "flag.example.com"},
+		},
+		{
+			name:     "proxy flag",
+			settings: map[string]interface{}{"proxy": "http://flag:3128"},
+			env:      map[string]string{"HTTPS_PROXY": "http://env:3128", "NO_PROXY": "env.example.com"},
+			want:     proxySettings{httpProxy: "http://flag:3128", httpsProxy: "http://flag:3128"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy"} {
+				old, existed := os.LookupEnv(name)
+				os.Setenv(name, tt.env[name])
+				defer func(name string) {
+					if existed {
+						os.Setenv(name, old)
+					} else {
+						os.Unsetenv(name)
+					}
+				}(name)
+			}
+			for key, value := range tt.settings {
+				viper.Set(key, value)
+			}
+			defer func() {
+				for key := range tt.settings {
+					viper.Set(key, nil)
+				}
+			}()
+
+			if got := getProxySettings(); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
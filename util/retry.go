@@ -0,0 +1,317 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// base delay of the exponential backoff (doubled for each retry, capped at "retry-max-wait")
+var retryBaseDelay = time.Second
+
+//MarkIdempotent marks a request with a method that isn't idempotent by definition (POST, PATCH) as safe to retry,
+//i.e. a login or an update that sets absolute values. Same convention as net/http: the header is not sent.
+func MarkIdempotent(req *http.Request) {
+	req.Header["Idempotency-Key"] = nil
+}
+
+//RetryPolicy holds the retry settings of a command and the rate limits that servers reported. The http clients of all
+//targets of a command share it, so that a rate limit that one target ran into is respected for the others, too.
+type RetryPolicy struct {
+	retries        int           // max number of retries ("--retries")
+	maxWait        time.Duration // max wait before a retry ("--retry-max-wait")
+	requestTimeout time.Duration // timeout of each attempt, including reading the response body ("--request-timeout", 0 = none)
+
+	mu          sync.Mutex
+	rateLimited map[string]time.Time // host -> end of its rate limit
+}
+
+//NewRetryPolicy returns a retry policy. Negative retries or max waits are an error.
+func NewRetryPolicy(retries int, maxWait time.Duration, requestTimeout time.Duration) (*RetryPolicy, error) {
+	if retries < 0 {
+		return nil, fmt.Errorf("\"--retries\" must not be negative")
+	}
+	if maxWait < 0 {
+		return nil, fmt.Errorf("\"--retry-max-wait\" must not be negative")
+	}
+	if requestTimeout < 0 {
+		return nil, fmt.Errorf("\"--request-timeout\" must not be negative")
+	}
+	return &RetryPolicy{retries: retries, maxWait: maxWait, requestTimeout: requestTimeout, rateLimited: map[string]time.Time{}}, nil
+}
+
+// retryPolicy is the policy of the current command (see SetRetryPolicy)
+var retryPolicy *RetryPolicy
+
+//SetRetryPolicy sets the retry policy that the http clients of NewHTTPClient use (until it's set again). Without a policy,
+//each http client gets its own policy with the settings from the config.
+func SetRetryPolicy(policy *RetryPolicy) {
+	retryPolicy = policy
+}
+
+// currentRetryPolicy returns the policy of the current command or a new one with the settings from the config
+func currentRetryPolicy() *RetryPolicy {
+	if retryPolicy != nil {
+		return retryPolicy
+	}
+	policy, err := NewRetryPolicy(viper.GetInt("retries"), viper.GetDuration("retry-max-wait"), viper.GetDuration("request-timeout"))
+	if err != nil {
+		log.Debug(err, ", retries are disabled")
+		policy, _ = NewRetryPolicy(0, 0, 0)
+	}
+	return policy
+}
+
+// rateLimitWait returns how long a server is still rate limited
+func (p *RetryPolicy) rateLimitWait(host string) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return nonNegative(time.Until(p.rateLimited[host]))
+}
+
+// recordRateLimit remembers the end of a rate limit that a response reports (429, or used up X-RateLimit-Remaining)
+func (p *RetryPolicy) recordRateLimit(host string, res *http.Response) {
+	if res == nil || (res.StatusCode != http.StatusTooManyRequests && res.Header.Get("X-RateLimit-Remaining") != "0") {
+		return
+	}
+	wait, ok := serverWaitTime(res)
+	if !ok {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if end := time.Now().Add(wait); end.After(p.rateLimited[host]) {
+		p.rateLimited[host] = end
+	}
+}
+
+// retryTransport retries requests that failed with a transient error (network errors, timeouts, 408, 429, 502, 503, 504)
+// with exponential backoff and jitter. It honors Retry-After and Dockerhub's X-RateLimit-* headers.
+type retryTransport struct {
+	next   http.RoundTripper
+	policy *RetryPolicy
+}
+
+// newRetryTransport wraps a transport with the retry policy of the current command
+func newRetryTransport(next http.RoundTripper) http.RoundTripper {
+	return &retryTransport{next: next, policy: currentRetryPolicy()}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// another request ran into the rate limit of the server. Longer waits are left to the server (it responds with 429 again).
+	if wait := t.policy.rateLimitWait(req.URL.Host); wait > 0 && wait <= t.policy.maxWait {
+		log.Debug(req.URL.Host, " is rate limited, waiting ", wait.Round(time.Millisecond), " before ", req.Method, " ", req.URL.Redacted())
+		if err := sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := req.Context(), context.CancelFunc(func() {})
+		if t.policy.requestTimeout > 0 {
+			ctx, cancel = context.WithTimeout(req.Context(), t.policy.requestTimeout)
+		}
+
+		attemptReq := req.Clone(ctx)
+		if attempt > 0 && req.Body != nil {
+			// the body was consumed by the previous attempt
+			body, err := req.GetBody()
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			attemptReq.Body = body
+		}
+
+		res, err := t.next.RoundTrip(attemptReq)
+		if err != nil {
+			cancel()
+		} else {
+			res.Body = &cancelOnClose{ReadCloser: res.Body, cancel: cancel}
+		}
+		logAttempt(req, attempt, t.policy.retries, res, err)
+		t.policy.recordRateLimit(req.URL.Host, res)
+
+		if attempt >= t.policy.retries || !t.retryable(req, res, err) {
+			return res, err
+		}
+
+		wait, ok := t.policy.waitTime(attempt, res)
+		if !ok {
+			return res, err
+		}
+		log.Debug("retrying ", req.Method, " ", req.URL.Redacted(), " in ", wait.Round(time.Millisecond))
+
+		if res != nil {
+			// the response of a failed attempt isn't needed anymore
+			res.Body.Close()
+		}
+
+		if err := sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// cancelOnClose releases the timeout of a request when its response body gets closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// retryable checks if a failed request can be sent again without side effects
+func (t *retryTransport) retryable(req *http.Request, res *http.Response, err error) bool {
+	if req.Body != nil && req.GetBody == nil {
+		return false
+	}
+	if req.Context().Err() != nil {
+		return false
+	}
+
+	if err != nil {
+		// the connection was never established, the server didn't get the request
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return true
+		}
+		return isIdempotent(req)
+	}
+
+	switch res.StatusCode {
+	case http.StatusTooManyRequests:
+		// rate limited requests were not processed
+		return true
+	case http.StatusRequestTimeout, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return isIdempotent(req)
+	default:
+		return false
+	}
+}
+
+// isIdempotent checks if a request can be repeated without changing the result
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	_, marked := req.Header["Idempotency-Key"]
+	return marked
+}
+
+// waitTime returns the wait before the next attempt: the time that the server asks for (Retry-After, X-RateLimit-Reset)
+// or the backoff. Returns false if the server asks for a wait that is longer than the max wait.
+func (p *RetryPolicy) waitTime(attempt int, res *http.Response) (time.Duration, bool) {
+	if wait, ok := serverWaitTime(res); ok {
+		if wait > p.maxWait {
+			log.Debug("server asks to wait ", wait.Round(time.Second), " before a retry, that's more than the max wait of ", p.maxWait, " (\"--retry-max-wait\"). Giving up.")
+			return 0, false
+		}
+		return wait, true
+	}
+
+	backoff := p.maxWait
+	if attempt < 30 && retryBaseDelay<<uint(attempt) < p.maxWait {
+		backoff = retryBaseDelay << uint(attempt)
+	}
+	if backoff <= 0 {
+		return 0, true
+	}
+	// equal jitter: between half and the full backoff, so that concurrent clients don't retry at the same time
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1)), true
+}
+
+// serverWaitTime returns the wait that a response asks for with a Retry-After header (seconds or http date) or, if the
+// rate limit is used up, with Dockerhub's X-RateLimit-Reset header (unix time)
+func serverWaitTime(res *http.Response) (time.Duration, bool) {
+	if res == nil {
+		return 0, false
+	}
+
+	if retryAfter := res.Header.Get("Retry-After"); retryAfter != "" {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if date, err := http.ParseTime(retryAfter); err == nil {
+			return nonNegative(time.Until(date)), true
+		}
+	}
+
+	if res.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(res.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			return nonNegative(time.Until(time.Unix(reset, 0))), true
+		}
+	}
+
+	return 0, false
+}
+
+func nonNegative(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// logAttempt logs an http request attempt and the rate limit of the server (if it sends one)
+func logAttempt(req *http.Request, attempt int, retries int, res *http.Response, err error) {
+	prefix := "http " + req.Method + " " + req.URL.Redacted() + " (attempt " + strconv.Itoa(attempt+1) + "/" + strconv.Itoa(retries+1) + "): "
+	if err != nil {
+		log.Debug(prefix, err)
+		return
+	}
+	log.Debug(prefix, res.Status)
+
+	if remaining := res.Header.Get("X-RateLimit-Remaining"); remaining != "" {
+		log.Debug("rate limit: ", remaining, " of ", res.Header.Get("X-RateLimit-Limit"), " requests remaining, reset at ", res.Header.Get("X-RateLimit-Reset"))
+	}
+}
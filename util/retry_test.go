@@ -0,0 +1,197 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewRetryPolicy(t *testing.T) {
+	tests := []struct {
+		retries        int
+		maxWait        time.Duration
+		requestTimeout time.Duration
+		wantErr        bool
+	}{
+		{retries: 3, maxWait: time.Minute, requestTimeout: time.Minute},
+		{retries: 0, maxWait: 0, requestTimeout: 0},
+		{retries: -1, maxWait: time.Minute, requestTimeout: time.Minute, wantErr: true},
+		{retries: 3, maxWait: -time.Second, requestTimeout: time.Minute, wantErr: true},
+		{retries: 3, maxWait: time.Minute, requestTimeout: -time.Second, wantErr: true},
+	}
+	for _, tt := range tests {
+		_, err := NewRetryPolicy(tt.retries, tt.maxWait, tt.requestTimeout)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewRetryPolicy(%d, %v, %v): got error %v, want error: %v", tt.retries, tt.maxWait, tt.requestTimeout, err, tt.wantErr)
+		}
+	}
+}
+
+func TestServerWaitTime(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		header   http.Header
+		want     time.Duration
+		wantOK   bool
+		tolerant bool // the wait depends on the clock
+	}{
+		{name: "no header", header: http.Header{}},
+		{name: "Retry-After seconds", header: http.Header{"Retry-After": {"120"}}, want: 2 * time.Minute, wantOK: true},
+		{name: "Retry-After zero", header: http.Header{"Retry-After": {"0"}}, want: 0, wantOK: true},
+		{name: "Retry-After negative", header: http.Header{"Retry-After": {"-5"}}},
+		{name: "Retry-After garbage", header: http.Header{"Retry-After": {"soon"}}},
+		{name: "Retry-After http date", header: http.Header{"Retry-After": {future.UTC().Format(http.TimeFormat)}}, want: time.Hour, wantOK: true, tolerant: true},
+		{name: "Retry-After http date in the past", header: http.Header{"Retry-After": {"Mon, 02 Jan 2006 15:04:05 GMT"}}, want: 0, wantOK: true},
+		{name: "rate limit used up", header: http.Header{"X-Ratelimit-Remaining": {"0"}, "X-Ratelimit-Reset": {strconv.FormatInt(future.Unix(), 10)}}, want: time.Hour, wantOK: true, tolerant: true},
+		{name: "rate limit not used up", header: http.Header{"X-Ratelimit-Remaining": {"5"}, "X-Ratelimit-Reset": {strconv.FormatInt(future.Unix(), 10)}}},
+		{name: "Retry-After takes precedence", header: http.Header{"Retry-After": {"1"}, "X-Ratelimit-Remaining": {"0"}, "X-Ratelimit-Reset": {strconv.FormatInt(future.Unix(), 10)}}, want: time.Second, wantOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := serverWaitTime(&http.Response{StatusCode: 429, Header: tt.header})
+			if ok != tt.wantOK {
+				t.Fatalf("got ok %v, want %v", ok, tt.wantOK)
+			}
+			if tt.tolerant && (got < tt.want-2*time.Second || got > tt.want) || !tt.tolerant && got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, ok := serverWaitTime(nil); ok {
+		t.Errorf("got a wait without a response")
+	}
+}
+
+func TestWaitTime(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxWait time.Duration
+		attempt int
+		header  http.Header
+		min     time.Duration
+		max     time.Duration
+		wantOK  bool
+	}{
+		{name: "first backoff", maxWait: time.Minute, attempt: 0, min: retryBaseDelay / 2, max: retryBaseDelay, wantOK: true},
+		{name: "backoff doubles", maxWait: time.Minute, attempt: 2, min: 2 * retryBaseDelay, max: 4 * retryBaseDelay, wantOK: true},
+		{name: "backoff is capped at the max wait", maxWait: 3 * time.Second, attempt: 10, min: 1500 * time.Millisecond, max: 3 * time.Second, wantOK: true},
+		{name: "no overflow for many attempts", maxWait: time.Minute, attempt: 100, min: 30 * time.Second, max: time.Minute, wantOK: true},
+		{name: "zero max wait", maxWait: 0, attempt: 0, min: 0, max: 0, wantOK: true},
+		{name: "server wait", maxWait: time.Minute, header: http.Header{"Retry-After": {"30"}}, min: 30 * time.Second, max: 30 * time.Second, wantOK: true},
+		{name: "server wait longer than the max wait", maxWait: time.Minute, header: http.Header{"Retry-After": {"3600"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := NewRetryPolicy(3, tt.maxWait, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := &http.Response{StatusCode: 503, Header: tt.header}
+			if tt.header == nil {
+				res.Header = http.Header{}
+			}
+			for i := 0; i < 20; i++ {
+				got, ok := policy.waitTime(tt.attempt, res)
+				if ok != tt.wantOK {
+					t.Fatalf("got ok %v, want %v", ok, tt.wantOK)
+				}
+				if ok && (got < tt.min || got > tt.max) {
+					t.Fatalf("got %v, want between %v and %v", got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryPolicyRateLimit(t *testing.T) {
+	policy, err := NewRetryPolicy(3, time.Minute, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy.recordRateLimit("registry.example.com", &http.Response{StatusCode: 503, Header: http.Header{"Retry-After": {"30"}}})
+	if wait := policy.rateLimitWait("registry.example.com"); wait != 0 {
+		t.Errorf("got a rate limit of %v after a 503, want none", wait)
+	}
+
+	policy.recordRateLimit("registry.example.com", &http.Response{StatusCode: 429, Header: http.Header{"Retry-After": {"30"}}})
+	if wait := policy.rateLimitWait("registry.example.com"); wait < 29*time.Second || wait > 30*time.Second {
+		t.Errorf("got a rate limit of %v, want 30s", wait)
+	}
+	if wait := policy.rateLimitWait("other.example.com"); wait != 0 {
+		t.Errorf("got a rate limit of %v for another host, want none", wait)
+	}
+
+	// a shorter rate limit doesn't shorten the known one
+	policy.recordRateLimit("registry.example.com", &http.Response{StatusCode: 429, Header: http.Header{"Retry-After": {"1"}}})
+	if wait := policy.rateLimitWait("registry.example.com"); wait < 29*time.Second {
+		t.Errorf("got a rate limit of %v, want 30s", wait)
+	}
+}
+
+func TestRetryTransportSharesRateLimit(t *testing.T) {
+	var mu sync.Mutex
+	var times []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		times = append(times, time.Now())
+		if len(times) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	// no retries: the first client gives up, the second one (i.e. of the next target) waits for the rate limit
+	policy, err := NewRetryPolicy(0, time.Minute, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []int{http.StatusTooManyRequests, http.StatusOK} {
+		client := &http.Client{Transport: &retryTransport{next: http.DefaultTransport, policy: policy}}
+		res, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != want {
+			t.Errorf("request %d: got status %d, want %d", i+1, res.StatusCode, want)
+		}
+	}
+
+	if len(times) != 2 {
+		t.Fatalf("got %d requests, want 2", len(times))
+	}
+	if wait := times[1].Sub(times[0]); wait < 900*time.Millisecond {
+		t.Errorf("second request was sent %v after the rate limited one, want 1s", wait)
+	}
+}
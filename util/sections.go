@@ -0,0 +1,192 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// markers in a README that control which parts get pushed
+const (
+	markerStart = "start" // <!-- pushrm:start --> only the content between start and end markers gets pushed
+	markerEnd   = "end"   // <!-- pushrm:end -->
+	markerSkip  = "skip"  // <!-- pushrm:skip --> the section that follows the marker doesn't get pushed
+)
+
+var sectionMarker = regexp.MustCompile(`^\s*<!--\s*pushrm:(start|end|skip)\s*-->\s*$`)
+var atxHeading = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+var setextUnderline = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+
+// markdownLine is a line of a markdown document
+type markdownLine struct {
+	raw     string // line including the line break
+	code    bool   // line is part of a fenced code block
+	level   int    // heading level (0 if the line isn't a heading)
+	heading string // heading text
+}
+
+// parseMarkdownLines splits a markdown document into lines and detects code blocks and headings (ATX and setext)
+func parseMarkdownLines(markdown string) []markdownLine {
+	var lines []markdownLine
+	fence := ""
+
+	for _, raw := range strings.SplitAfter(markdown, "\n") {
+		if raw == "" {
+			continue
+		}
+		content := strings.TrimRight(raw, "\r\n")
+		trimmed := strings.TrimLeft(content, " ")
+		indented := len(content)-len(trimmed) >= 4 || strings.HasPrefix(content, "\t")
+		line := markdownLine{raw: raw}
+
+		switch {
+		case fence != "":
+			line.code = true
+			if !indented && strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]+" \t") == "" {
+				fence = ""
+			}
+		case !indented && fenceMarker(trimmed) != "":
+			line.code = true
+			fence = fenceMarker(trimmed)
+		case atxHeading.MatchString(content):
+			m := atxHeading.FindStringSubmatch(content)
+			line.level = len(m[1])
+			line.heading = strings.TrimSpace(m[2])
+		case setextUnderline.MatchString(content) && len(lines) > 0:
+			// the underline turns the previous (paragraph) line into a heading
+			prev := &lines[len(lines)-1]
+			prevContent := strings.TrimSpace(prev.raw)
+			if !prev.code && prev.level == 0 && prevContent != "" && !sectionMarker.MatchString(prev.raw) {
+				prev.level = 2
+				if strings.HasPrefix(trimmed, "=") {
+					prev.level = 1
+				}
+				prev.heading = prevContent
+			}
+		}
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// ApplyMarkers removes the parts of a README that are excluded by pushrm markers (and the markers themselves).
+// READMEs without markers are returned unchanged.
+func ApplyMarkers(readme string) (string, error) {
+	lines := parseMarkdownLines(readme)
+
+	hasMarkers := false
+	hasStart := false
+	for _, line := range lines {
+		if m := sectionMarker.FindStringSubmatch(line.raw); m != nil && !line.code {
+			hasMarkers = true
+			if m[1] == markerStart {
+				hasStart = true
+			}
+		}
+	}
+	if !hasMarkers {
+		return readme, nil
+	}
+
+	var sb strings.Builder
+	include := !hasStart
+	skipping := false
+	skipLevel := 0 // level of the skipped section (0 until the content after the marker is found, -1 if it isn't a heading)
+
+	for i, line := range lines {
+		if m := sectionMarker.FindStringSubmatch(line.raw); m != nil && !line.code {
+			switch m[1] {
+			case markerStart:
+				if include {
+					return "", fmt.Errorf("README line %d: unexpected <!-- pushrm:start --> (already started)", i+1)
+				}
+				include = true
+			case markerEnd:
+				if !include || !hasStart {
+					return "", fmt.Errorf("README line %d: <!-- pushrm:end --> without <!-- pushrm:start -->", i+1)
+				}
+				include = false
+			case markerSkip:
+				skipping = true
+				skipLevel = 0
+			}
+			continue
+		}
+
+		blank := !line.code && strings.TrimSpace(line.raw) == ""
+		if skipping {
+			switch {
+			case skipLevel == 0 && line.level > 0:
+				// a heading follows the marker: skip its section
+				skipLevel = line.level
+			case skipLevel == 0 && !blank:
+				// a paragraph (or list, code block...) follows the marker: skip it up to the next blank line or heading
+				skipLevel = -1
+			case skipLevel > 0 && line.level > 0 && line.level <= skipLevel:
+				skipping = false
+			case skipLevel < 0 && (blank || line.level > 0):
+				skipping = false
+			}
+		}
+
+		if include && !skipping {
+			sb.WriteString(line.raw)
+		}
+	}
+
+	return sb.String(), nil
+}
+
+// ExtractSections returns the sections of a README with the given headings (case-insensitive), including their subsections
+func ExtractSections(readme string, names []string) (string, error) {
+	lines := parseMarkdownLines(readme)
+	var sb strings.Builder
+
+	for _, name := range names {
+		start := -1
+		for i, line := range lines {
+			if line.level > 0 && strings.EqualFold(line.heading, strings.TrimSpace(name)) {
+				start = i
+				break
+			}
+		}
+		if start < 0 {
+			return "", fmt.Errorf("section %q not found in README", name)
+		}
+
+		for i := start; i < len(lines); i++ {
+			if i > start && lines[i].level > 0 && lines[i].level <= lines[start].level {
+				break
+			}
+			sb.WriteString(lines[i].raw)
+		}
+		if !strings.HasSuffix(sb.String(), "\n") {
+			sb.WriteString("\n")
+		}
+	}
+
+	return sb.String(), nil
+}
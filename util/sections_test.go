@@ -0,0 +1,179 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package util
+
+import "testing"
+
+func TestApplyMarkers(t *testing.T) {
+	tests := []struct {
+		name    string
+		readme  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "no markers",
+			readme: "# title\n\n<!-- other comment -->\ntext\n",
+			want:   "# title\n\n<!-- other comment -->\ntext\n",
+		},
+		{
+			name:   "skip a section up to the next heading of the same level",
+			readme: "# title\nintro\n<!-- pushrm:skip -->\n## build\nbuild text\n### details\nmore\n## usage\nusage text\n",
+			want:   "# title\nintro\n## usage\nusage text\n",
+		},
+		{
+			name:   "skip a section up to the next heading of a higher level",
+			readme: "# a\n<!-- pushrm:skip -->\n## b\nb text\n# c\nc text\n",
+			want:   "# a\n# c\nc text\n",
+		},
+		{
+			name:   "skip a section with a blank line after the marker",
+			readme: "# a\n<!-- pushrm:skip -->\n\n## b\nb text\n## c\n",
+			want:   "# a\n## c\n",
+		},
+		{
+			name:   "skip a setext section",
+			readme: "intro\n\n<!-- pushrm:skip -->\nBuild\n-----\nbuild text\n\nUsage\n-----\nusage text\n",
+			want:   "intro\n\nUsage\n-----\nusage text\n",
+		},
+		{
+			name:   "skip only the paragraph after the marker",
+			readme: "# a\n<!-- pushrm:skip -->\nskipped line 1\nskipped line 2\n\nkept\n## b\nb text\n",
+			want:   "# a\n\nkept\n## b\nb text\n",
+		},
+		{
+			name:   "skip a paragraph that is directly followed by a heading",
+			readme: "# a\n<!-- pushrm:skip -->\nskipped\n## b\nb text\n",
+			want:   "# a\n## b\nb text\n",
+		},
+		{
+			name:   "skip a code block with blank lines",
+			readme: "# a\n<!-- pushrm:skip -->\n```\nskipped\n\nskipped\n```\n\nkept\n",
+			want:   "# a\n\nkept\n",
+		},
+		{
+			name:   "skip up to the end of the document",
+			readme: "# a\ntext\n<!-- pushrm:skip -->\n## b\nb text\n### c\n",
+			want:   "# a\ntext\n",
+		},
+		{
+			name:   "start and end",
+			readme: "# a\nbadges\n<!-- pushrm:start -->\nkept 1\n<!-- pushrm:end -->\nleft out\n<!-- pushrm:start -->\nkept 2\n<!-- pushrm:end -->\n",
+			want:   "kept 1\nkept 2\n",
+		},
+		{
+			name:   "skip within start and end",
+			readme: "<!-- pushrm:start -->\n# a\n<!-- pushrm:skip -->\n## b\nb text\n## c\n<!-- pushrm:end -->\n## d\n",
+			want:   "# a\n## c\n",
+		},
+		{
+			name:   "markers in code blocks are ignored",
+			readme: "# a\n```\n<!-- pushrm:skip -->\n```\n## b\n",
+			want:   "# a\n```\n<!-- pushrm:skip -->\n```\n## b\n",
+		},
+		{
+			name:   "markers with whitespace",
+			readme: "# a\n  <!--pushrm:skip   -->\n## b\n## c\n",
+			want:   "# a\n## c\n",
+		},
+		{
+			name:    "start twice",
+			readme:  "<!-- pushrm:start -->\na\n<!-- pushrm:start -->\n",
+			wantErr: true,
+		},
+		{
+			name:    "end without start",
+			readme:  "a\n<!-- pushrm:end -->\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ApplyMarkers(tt.readme)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractSections(t *testing.T) {
+	readme := "# Title\nintro\n## Usage\nusage text\n### Options\noptions text\n## Build\nbuild text\n```\n# not a heading\n```\nConfiguration\n-------------\nconfig text\n"
+
+	tests := []struct {
+		name     string
+		sections []string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "section with subsections",
+			sections: []string{"Usage"},
+			want:     "## Usage\nusage text\n### Options\noptions text\n",
+		},
+		{
+			name:     "case-insensitive",
+			sections: []string{" options "},
+			want:     "### Options\noptions text\n",
+		},
+		{
+			name:     "headings in code blocks are ignored",
+			sections: []string{"Build"},
+			want:     "## Build\nbuild text\n```\n# not a heading\n```\n",
+		},
+		{
+			name:     "setext heading",
+			sections: []string{"Configuration"},
+			want:     "Configuration\n-------------\nconfig text\n",
+		},
+		{
+			name:     "several sections in the given order",
+			sections: []string{"Configuration", "Options"},
+			want:     "Configuration\n-------------\nconfig text\n### Options\noptions text\n",
+		},
+		{
+			name:     "top level section",
+			sections: []string{"Title"},
+			want:     readme,
+		},
+		{
+			name:     "missing section",
+			sections: []string{"Install"},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractSections(readme, tt.sections)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
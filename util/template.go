@@ -0,0 +1,117 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package util
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// TemplateFileSuffix marks README files that get rendered as Go template
+const TemplateFileSuffix = ".tmpl"
+
+// TemplateTarget is the push target as seen by a README template
+type TemplateTarget struct {
+	Server     string // e.g. "docker.io"
+	Namespace  string // e.g. "my-user"
+	Repo       string // e.g. "hello-world"
+	Repository string // e.g. "my-user/hello-world"
+	Tag        string // e.g. "latest"
+	Digest     string // e.g. "sha256:..." (if specified)
+}
+
+// TemplateData is the data model for README templates
+type TemplateData struct {
+	Target TemplateTarget
+	Env    map[string]string // environment variables
+	Values map[string]string // values from "--set key=value"
+	Git    GitInfo
+}
+
+// template errors: "template: <name>:<line>[:<col>]: <message>"
+var templateError = regexp.MustCompile(`^template: .*?:(\d+)(?::(\d+))?: (.*)$`)
+
+// IsTemplateFile checks if a README file needs to be rendered
+func IsTemplateFile(file string) bool {
+	return strings.HasSuffix(file, TemplateFileSuffix)
+}
+
+// EnvMap returns the environment variables as map
+func EnvMap() map[string]string {
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if i := strings.Index(kv, "="); i > 0 {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+	return env
+}
+
+// RenderTemplate renders a README template. Errors point to the line and column in the template file.
+func RenderTemplate(file string, text string, data TemplateData) (string, error) {
+	tmpl, err := template.New(file).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", templateErrorf(file, text, err)
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", templateErrorf(file, text, err)
+	}
+
+	return sb.String(), nil
+}
+
+// templateErrorf reformats a template error to "<file>:<line>:<col>: <message>" followed by the affected line
+func templateErrorf(file string, text string, err error) error {
+	m := templateError.FindStringSubmatch(err.Error())
+	if m == nil {
+		return fmt.Errorf("error rendering template %s: %w", file, err)
+	}
+
+	lineno, _ := strconv.Atoi(m[1])
+	lines := strings.Split(text, "\n")
+	if lineno < 1 || lineno > len(lines) {
+		return fmt.Errorf("%s:%s: %s", file, m[1], m[3])
+	}
+	line := lines[lineno-1]
+
+	col := 0
+	if m[2] != "" {
+		// execution errors have a (0-based) byte offset in the line
+		col, _ = strconv.Atoi(m[2])
+		col++
+	} else {
+		// parse errors only have a line number, point to the first action of the line
+		col = strings.Index(line, "{{") + 1
+	}
+	if col < 1 {
+		col = 1
+	}
+
+	return fmt.Errorf("%s:%d:%d: %s\n\n    %s\n    %s^", file, lineno, col, m[3], line, strings.Repeat(" ", col-1))
+}
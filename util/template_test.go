@@ -0,0 +1,121 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	data := TemplateData{
+		Target: TemplateTarget{Server: "docker.io", Namespace: "my-user", Repo: "hello-world", Repository: "my-user/hello-world", Tag: "latest"},
+		Env:    map[string]string{"CI_COMMIT_TAG": "v1.2.3"},
+		Values: map[string]string{"version": "1.2.3"},
+		Git:    GitInfo{ShortCommit: "abc1234", Branch: "main"},
+	}
+
+	tests := []struct {
+		name    string
+		text    string
+		want    string
+		wantErr string // prefix of the error message
+	}{
+		{
+			name: "target, env, values and git",
+			text: "# {{ .Target.Repository }}:{{ .Target.Tag }}\n{{ .Env.CI_COMMIT_TAG }} {{ .Values.version }} {{ .Git.ShortCommit }}@{{ .Git.Branch }}\n",
+			want: "# my-user/hello-world:latest\nv1.2.3 1.2.3 abc1234@main\n",
+		},
+		{
+			name: "conditionals",
+			text: "{{ if .Target.Digest }}pinned{{ else }}{{ .Target.Server }}{{ end }}\n",
+			want: "docker.io\n",
+		},
+		{
+			name:    "missing value",
+			text:    "# title\nversion: {{ .Values.missing }}\n",
+			wantErr: "README.md.tmpl:2:20: ",
+		},
+		{
+			name:    "missing env var",
+			text:    "{{ .Env.NOT_SET }}\n",
+			wantErr: "README.md.tmpl:1:8: ",
+		},
+		{
+			name:    "unknown field",
+			text:    "\n\n  {{ .Target.Unknown }}\n",
+			wantErr: "README.md.tmpl:3:",
+		},
+		{
+			name:    "parse error",
+			text:    "# title\nx {{ end }}\n",
+			wantErr: "README.md.tmpl:2:3: ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RenderTemplate("README.md.tmpl", tt.text, data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want error starting with %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderTemplateErrorPointsToTheLine(t *testing.T) {
+	_, err := RenderTemplate("README.md.tmpl", "# title\nversion: {{ .Values.missing }}\n", TemplateData{Values: map[string]string{}})
+	if err == nil {
+		t.Fatal("got no error")
+	}
+	// the column points to the key that is missing
+	want := "\n\n    version: {{ .Values.missing }}\n                       ^"
+	if !strings.HasSuffix(err.Error(), want) {
+		t.Errorf("got error %q, want it to end with %q", err.Error(), want)
+	}
+}
+
+func TestIsTemplateFile(t *testing.T) {
+	tests := []struct {
+		file string
+		want bool
+	}{
+		{"README.md.tmpl", true},
+		{"docs/README-containers.md.tmpl", true},
+		{"README.md", false},
+		{"README.tmpl.md", false},
+	}
+	for _, tt := range tests {
+		if got := IsTemplateFile(tt.file); got != tt.want {
+			t.Errorf("IsTemplateFile(%q) = %v, want %v", tt.file, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package util
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// truncation strategies for READMEs that are larger than the provider allows
+const (
+	TruncateNone    = "none"    // fail
+	TruncateHard    = "hard"    // cut at the size limit
+	TruncateHeading = "heading" // cut before the last heading that fits
+	TruncateFooter  = "footer"  // cut before the last heading that fits and append a "read more" link
+)
+
+// TruncateStrategies lists the valid truncation strategies
+var TruncateStrategies = []string{TruncateNone, TruncateHard, TruncateHeading, TruncateFooter}
+
+// TruncateReadme shortens a README to max characters using the given strategy. readmoreURL is required for the "footer" strategy.
+func TruncateReadme(readme string, max int, strategy string, readmoreURL string) (string, error) {
+	size := utf8.RuneCountInString(readme)
+	if max <= 0 || size <= max {
+		return readme, nil
+	}
+
+	switch strategy {
+	case TruncateHard:
+		return truncateBytes(readme, charsToBytes(readme, max)), nil
+	case TruncateHeading:
+		return truncateAtHeading(readme, charsToBytes(readme, max)), nil
+	case TruncateFooter:
+		if readmoreURL == "" {
+			return "", fmt.Errorf("truncate strategy \"footer\" needs a url for the full README (\"--readmore-url <url>\")")
+		}
+		footer := "\n\n---\n\n*This README is truncated. Read more at [" + readmoreURL + "](" + readmoreURL + ")*\n"
+		footerSize := utf8.RuneCountInString(footer)
+		if footerSize >= max {
+			return "", fmt.Errorf("the \"read more\" footer doesn't fit into the size limit of %d characters", max)
+		}
+		return strings.TrimRight(truncateAtHeading(readme, charsToBytes(readme, max-footerSize)), "\n") + footer, nil
+	default:
+		return "", fmt.Errorf("README is too large (%d characters, max %d characters)", size, max)
+	}
+}
+
+// charsToBytes returns the length in bytes of the first n characters of s.
+// Any cut of s within this length has at most n characters.
+func charsToBytes(s string, n int) int {
+	for i := range s {
+		if n == 0 {
+			return i
+		}
+		n--
+	}
+	return len(s)
+}
+
+// truncateBytes cuts s to at most max bytes without splitting a multi-byte character
+func truncateBytes(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
+
+// truncateAtHeading cuts s before the last heading (outside of code blocks) that starts within max bytes.
+// Falls back to the last line break (or a hard cut) if there is no such heading.
+func truncateAtHeading(s string, max int) string {
+	lastHeading := 0
+	lastLine := 0
+	fence := ""
+
+	pos := 0
+	for _, line := range strings.SplitAfter(s, "\n") {
+		if pos > max {
+			break
+		}
+		trimmed := strings.TrimLeft(line, " ")
+		indented := len(line)-len(trimmed) >= 4
+
+		switch {
+		case fence != "":
+			if !indented && strings.HasPrefix(trimmed, fence) && strings.Trim(strings.TrimSpace(trimmed), fence[:1]) == "" {
+				fence = ""
+			}
+		case !indented && fenceMarker(trimmed) != "":
+			fence = fenceMarker(trimmed)
+			// don't cut inside a code block
+			lastLine = pos
+		case !indented && strings.HasPrefix(trimmed, "#") && pos > 0:
+			lastHeading = pos
+			lastLine = pos
+		default:
+			lastLine = pos
+		}
+		pos += len(line)
+	}
+
+	switch {
+	case lastHeading > 0:
+		return s[:lastHeading]
+	case lastLine > 0:
+		return s[:lastLine]
+	default:
+		return truncateBytes(s, max)
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package util
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateReadme(t *testing.T) {
+	umlauts := strings.Repeat("ä", 10) // 10 characters, 20 bytes
+
+	tests := []struct {
+		name     string
+		readme   string
+		max      int
+		strategy string
+		want     string
+		wantErr  bool
+	}{
+		{name: "no limit", readme: umlauts, max: 0, strategy: TruncateNone, want: umlauts},
+		{name: "limit counts characters, not bytes", readme: umlauts, max: 10, strategy: TruncateNone, want: umlauts},
+		{name: "too large", readme: umlauts, max: 9, strategy: TruncateNone, wantErr: true},
+		{name: "hard cut after characters", readme: umlauts, max: 4, strategy: TruncateHard, want: "ääää"},
+		{name: "hard cut with mixed characters", readme: "a🐳b🐳c", max: 3, strategy: TruncateHard, want: "a🐳b"},
+		{
+			name:     "cut before the last heading that fits",
+			readme:   "# ä\nüüü\n## ö\nööö\n## é\néééééé\n",
+			max:      18,
+			strategy: TruncateHeading,
+			want:     "# ä\nüüü\n## ö\nööö\n",
+		},
+		{
+			name:     "footer",
+			readme:   "# ä\nüüü\n## ö\n" + strings.Repeat("ö", 100) + "\n",
+			max:      90,
+			strategy: TruncateFooter,
+			want:     "# ä\nüüü\n\n---\n\n*This README is truncated. Read more at [https://x.io](https://x.io)*\n",
+		},
+		{name: "footer without url", readme: umlauts, max: 5, strategy: TruncateFooter, wantErr: true},
+		{name: "footer larger than the limit", readme: strings.Repeat(umlauts, 10), max: 50, strategy: TruncateFooter, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readmoreURL := ""
+			if tt.name != "footer without url" {
+				readmoreURL = "https://x.io"
+			}
+			got, err := TruncateReadme(tt.readme, tt.max, tt.strategy, readmoreURL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if tt.max > 0 && utf8.RuneCountInString(got) > tt.max {
+				t.Errorf("got %d characters, want at most %d", utf8.RuneCountInString(got), tt.max)
+			}
+		})
+	}
+}
@@ -23,14 +23,18 @@ THE SOFTWARE.
 package util
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
@@ -64,7 +68,7 @@ func ReadFile(path string) (filecontent string, error error) {
 	return text, nil
 }
 
-//GetApikey retrieves an API key from env var or the local Docker config file
+//GetApikey retrieves an API key from env var, the Docker credentials store (see StoreApikey) or the local Docker config file
 func GetApikey(servername string) (apikey string, error error) {
 	log.Debug("util.GetApikey called")
 
@@ -73,7 +77,7 @@ func GetApikey(servername string) (apikey string, error error) {
 		return genericEnvval, nil
 	}
 
-	envkey := "APIKEY__" + strings.ToUpper(strings.Replace(servername, ".", "_", -1))
+	envkey := "APIKEY__" + EnvSuffix(servername)
 	querykey := "plugins.docker-pushrm.apikey_" + servername
 
 	envval := os.Getenv(envkey)
@@ -81,115 +85,321 @@ func GetApikey(servername string) (apikey string, error error) {
 	if envval != "" {
 		apikey = envval
 		return apikey, nil
-	} else {
-		cfgval := viper.GetString(querykey)
-		if cfgval != "" {
-			apikey = cfgval
-			return apikey, nil
-		} else {
-			return "", fmt.Errorf("could not find api key for server " + servername + ". Either specify env var DOCKER_APIKEY or env var " + envkey + " or " + querykey + " in the local Docker config file. ")
+	}
+
+	if helper := apikeyCredHelper(servername); helper != "" {
+		stored, err := queryCredHelper(helper, ApikeyServerURL(servername))
+		if err == nil && stored.Password != "" {
+			log.Debug("using api key from credential helper ", helper)
+			return stored.Password, nil
 		}
+		log.Debug("no api key in credential helper ", helper, " for ", ApikeyServerURL(servername))
+	}
+
+	cfgval := viper.GetString(querykey)
+	if cfgval != "" {
+		apikey = cfgval
+		return apikey, nil
+	}
+
+	return "", fmt.Errorf("could not find api key for server %s. Either run \"docker pushrm login %s\" or specify env var DOCKER_APIKEY or env var %s or %s in the local Docker config file. ", servername, servername, envkey, querykey)
+}
+
+//ApikeyServerURL returns the server url under which "docker pushrm login" stores the api key of a server in the Docker
+//credentials store (separate from the registry login of "docker login"). It's an https url, as some credential helpers
+//(i.e. osxkeychain) don't accept other schemes.
+func ApikeyServerURL(servername string) string {
+	return "https://" + servername + "/docker-pushrm"
+}
 
+//StoreApikey stores an api key for a server with the Docker credential helper ("credHelpers" or "credsStore" in the
+//Docker config file). Api keys are never written to the config file in plaintext.
+func StoreApikey(servername string, username string, apikey string) error {
+	helper := apikeyCredHelper(servername)
+	if helper == "" {
+		return fmt.Errorf("no Docker credential helper configured (\"credsStore\" or \"credHelpers\" in the local Docker config file). The api key can't be stored securely. ")
 	}
+	log.Debug("storing api key for ", servername, " with credential helper ", helper)
 
+	dat, _ := json.Marshal(map[string]string{"ServerURL": ApikeyServerURL(servername), "Username": username, "Secret": apikey})
+	return runCredHelper(helper, "store", bytes.NewReader(dat))
+}
+
+//EraseApikey removes the api key of a server from the Docker credential helper
+func EraseApikey(servername string) error {
+	helper := apikeyCredHelper(servername)
+	if helper == "" {
+		return fmt.Errorf("no Docker credential helper configured (\"credsStore\" or \"credHelpers\" in the local Docker config file). ")
+	}
+	log.Debug("removing api key for ", servername, " from credential helper ", helper)
+
+	return runCredHelper(helper, "erase", strings.NewReader(ApikeyServerURL(servername)))
+}
+
+// apikeyCredHelper returns the credential helper for the api key of a server, same as for its registry login (empty if there is none)
+func apikeyCredHelper(servername string) string {
+	if helper := credHelperFor(servername); helper != "" {
+		return helper
+	}
+	return viper.GetString("credsStore")
+}
+
+//EnvSuffix converts a servername to an env var name suffix (example: "harbor.local:8443" -> "HARBOR_LOCAL_8443")
+func EnvSuffix(servername string) string {
+	return strings.ToUpper(strings.NewReplacer(".", "_", ":", "_", "[", "_", "]", "_").Replace(servername))
+}
+
+//BaseURL returns the https base url for a servername (works for servernames with port and bracketed IPv6 addresses).
+//For insecure registries that only speak plain http (see IsInsecureRegistry) it is the http url.
+func BaseURL(servername string) string {
+	u := url.URL{Scheme: "https", Host: servername}
+	if _, ok := plainHTTPHosts.Load(servername); ok {
+		u.Scheme = "http"
+	}
+	return u.String()
+}
+
+//APIBaseURL returns the base url for api calls of a provider. Config key "<provider>-url" (env var PUSHRM_<PROVIDER>_URL)
+//overrides the default url (i.e. to use a proxy, a self-hosted instance or a test server)
+func APIBaseURL(providername string, defaultURL string) string {
+	if override := viper.GetString(providername + "-url"); override != "" {
+		log.Debug("using api url ", override, " for provider ", providername)
+		return strings.TrimSuffix(override, "/")
+	}
+	return defaultURL
+}
+
+//ConvertToHostname strips scheme and path from a registry url (like Docker does for keys in the config file)
+func ConvertToHostname(registryURL string) string {
+	hostname := registryURL
+	if i := strings.Index(hostname, "://"); i >= 0 {
+		hostname = hostname[i+3:]
+	}
+	if i := strings.Index(hostname, "/"); i >= 0 {
+		hostname = hostname[:i]
+	}
+	return strings.ToLower(hostname)
+}
+
+// findAuthsKeys returns the keys of the "auths" section of the Docker config file that belong to a servername
+func findAuthsKeys(servername string) (keys []string) {
+	for key := range viper.GetStringMap("auths") {
+		if ConvertToHostname(key) == strings.ToLower(servername) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 //GetDockerCreds retrieves credentials from the Docker creds store
-func GetDockerCreds(authident string, authidentIsFuzzy bool) (dockerUser string, dockerPasswd string, error error) {
+func GetDockerCreds(authident string, authidentIsFuzzy bool) (creds DockerCreds, error error) {
 
 	var candidates []string
 	if authidentIsFuzzy == true {
 		candidates = []string{authident, ("https://" + authident), ("https://" + authident + "/"), ("http://" + authident), ("http://" + authident + "/")}
+		// config keys that point to the same host in a different notation (i.e. "https://harbor.local:8443/v2/")
+		for _, key := range findAuthsKeys(authident) {
+			if !StringInSlice(key, candidates) {
+				candidates = append(candidates, key)
+			}
+		}
 	} else {
 		candidates = []string{authident}
 	}
 	for _, candidate := range candidates {
-		dockerUser, dockerPasswd = "", ""
-		dockerUser, dockerPasswd, err := QueryDockerCreds(candidate)
+		creds, err := QueryDockerCreds(candidate)
 		if err != nil {
 			log.Debug("tried candidate " + candidate + ", got error: " + err.Error())
 		}
 
-		if dockerUser == "" && dockerPasswd == "" {
+		if creds.Empty() {
 			log.Debug("tried candidate " + candidate + ": could not find credentials")
 		} else {
-			log.Debug("tried candidate " + candidate + ": found credentials for user " + dockerUser)
-			return dockerUser, dockerPasswd, nil
+			log.Debug("tried candidate " + candidate + ": found credentials for user " + creds.Username)
+			return creds, nil
 		}
 
 	}
 
-	return "", "", fmt.Errorf("no Docker credentials found for this server/provider. Run 'docker login' first. ")
+	return DockerCreds{}, fmt.Errorf("no Docker credentials found for this server/provider. Run 'docker login' first. ")
 
 }
 
-//QueryDockerCreds fetches credentials for an authid
-func QueryDockerCreds(authident string) (dockerUser string, dockerPasswd string, error error) {
+//DockerCreds is a login from the Docker config file or a credential helper (like Docker's AuthConfig)
+type DockerCreds struct {
+	Username      string
+	Password      string
+	IdentityToken string // identity (refresh) token from an OAuth/SSO login
+	RegistryToken string // bearer token
+}
 
-	log.Debug("util.GetDockerCreds called")
+//Empty checks if no credentials were found
+func (c DockerCreds) Empty() bool {
+	return c.Username == "" && c.Password == "" && c.IdentityToken == "" && c.RegistryToken == ""
+}
 
-	if viper.GetString("auths."+authident+".auth") != "" {
+// username that credential helpers return for identity tokens
+const identityTokenUsername = "<token>"
 
-		credsb64 := viper.GetString("auths." + authident + ".auth")
-		credsclearb, err := base64.StdEncoding.DecodeString(credsb64)
-		if err != nil {
-			log.Debug(err)
-			return "", "", fmt.Errorf("Error parsing auth info from the Docker config file. Check your local Docker config. ")
+// errCredHelperNotFound is returned if a credential helper has no credentials for a server
+var errCredHelperNotFound = errors.New("no Docker credentials found for this server/provider. Run 'docker login' first. ")
+
+//QueryDockerCreds fetches credentials for an authid. The lookup order is the same as Docker's:
+//the credential helper for the host ("credHelpers"), the default credential helper ("credsStore"), inline credentials ("auths").
+//Inline credentials are also used if the default credential helper has no credentials for the authid.
+func QueryDockerCreds(authident string) (creds DockerCreds, error error) {
+
+	log.Debug("util.QueryDockerCreds called")
+
+	if helper := credHelperFor(authident); helper != "" {
+		log.Debug("using credential helper ", helper, " (credHelpers) for ", authident)
+		return queryCredHelper(helper, authident)
+	}
+
+	if helper := viper.GetString("credsStore"); helper != "" {
+		log.Debug("using credential helper ", helper, " (credsStore) for ", authident)
+		creds, err := queryCredHelper(helper, authident)
+		if err != errCredHelperNotFound {
+			return creds, err
 		}
-		credsclear := string(credsclearb)
-		credssplit := strings.Split(credsclear, ":")
-		dockerUser = credssplit[0]
-		dockerPasswd = credsclear[len(dockerUser)+1:]
+		log.Debug("credential helper ", helper, " has no credentials for ", authident, ", trying inline credentials (auths)")
+	}
 
-	} else {
-		if viper.GetString("credsStore") != "" {
-			executable := "docker-credential-" + viper.GetString("credsStore")
-			if viper.GetString("credsStore") == "wincred" {
-				executable = executable + ".exe"
-			}
-			shx := exec.Command(executable, "get")
-			stdin, err := shx.StdinPipe()
-			if err != nil {
-				log.Debug(err)
-				return "", "", fmt.Errorf("Error executing the Docker credentials helper. Check your local Docker config and/or installation. ")
-			}
+	return queryAuths(authident)
+}
+
+// credHelperFor returns the credential helper that is configured for the host of authident in "credHelpers" (empty if there is none)
+func credHelperFor(authident string) string {
+	helpers := viper.GetStringMapString("credHelpers")
+	if helper, ok := helpers[strings.ToLower(authident)]; ok {
+		return helper
+	}
+	// keys are hostnames, but can also be urls
+	var keys []string
+	for key := range helpers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if ConvertToHostname(key) == ConvertToHostname(authident) {
+			return helpers[key]
+		}
+	}
+	return ""
+}
 
-			done := make(chan bool)
+// queryCredHelper gets the credentials for serverURL from a Docker credential helper ("docker-credential-<helper> get")
+func queryCredHelper(helper string, serverURL string) (creds DockerCreds, error error) {
+	executable := credHelperExecutable(helper)
 
-			go func() {
-				defer func() { done <- true }()
-				defer stdin.Close()
-				io.WriteString(stdin, authident)
+	// the helper reads the server url from stdin and writes the credentials as json to stdout
+	var stdout, stderr bytes.Buffer
+	shx := exec.Command(executable, "get")
+	shx.Stdin = strings.NewReader(serverURL)
+	shx.Stdout = &stdout
+	shx.Stderr = &stderr
 
-			}()
+	if err := shx.Run(); err != nil {
+		log.Debug(err)
+		if _, ok := err.(*exec.ExitError); !ok {
+			return creds, fmt.Errorf("Error executing the Docker credential helper %s. Check your local Docker config and/or installation. ", executable)
+		}
+		// "credentials not found in native keychain" (or a similar message of other helpers)
+		log.Debug("credential helper ", executable, ": ", strings.TrimSpace(stdout.String()+" "+stderr.String()))
+		return creds, errCredHelperNotFound
+	}
+
+	var dat struct {
+		ServerURL string
+		Username  string
+		Secret    string
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &dat); err != nil {
+		log.Debug(err)
+		return creds, fmt.Errorf("Error parsing credentials from Docker creds provider. Run 'docker login' first. ")
+	}
 
-			<-done
+	if dat.Username == identityTokenUsername {
+		log.Debug("credential helper ", executable, " returned an identity token")
+		return DockerCreds{IdentityToken: dat.Secret}, nil
+	}
 
-			out, err := shx.CombinedOutput()
-			if err != nil {
-				log.Debug(err)
-				return "", "", fmt.Errorf("no Docker credentials found for this server/provider. Run 'docker login' first. ")
-			}
+	return DockerCreds{Username: dat.Username, Password: dat.Secret}, nil
+}
 
-			var dat map[string]interface{}
-			if err := json.Unmarshal(out, &dat); err != nil {
-				log.Debug(err)
-				return "", "", fmt.Errorf("Error parsing credentials from Docker creds provider. Run 'docker login' first. ")
-			}
-			dockerUser = dat["Username"].(string)
-			dockerPasswd = dat["Secret"].(string)
+// runCredHelper runs a Docker credential helper command that doesn't return anything ("store" or "erase")
+func runCredHelper(helper string, command string, stdin io.Reader) error {
+	executable := credHelperExecutable(helper)
 
-		} else {
-			return "", "", fmt.Errorf("no Docker credentials found for this server/provider. Run 'docker login' first. ")
+	var output bytes.Buffer
+	shx := exec.Command(executable, command)
+	shx.Stdin = stdin
+	shx.Stdout = &output
+	shx.Stderr = &output
+
+	if err := shx.Run(); err != nil {
+		log.Debug(err)
+		if _, ok := err.(*exec.ExitError); !ok {
+			return fmt.Errorf("Error executing the Docker credential helper %s. Check your local Docker config and/or installation. ", executable)
 		}
+		return fmt.Errorf("Docker credential helper %s %s failed: %s", executable, command, strings.TrimSpace(output.String()))
 	}
+	return nil
+}
 
-	return dockerUser, dockerPasswd, nil
+func credHelperExecutable(helper string) string {
+	if helper == "wincred" {
+		return "docker-credential-" + helper + ".exe"
+	}
+	return "docker-credential-" + helper
+}
+
+// queryAuths gets inline credentials for authident from the "auths" section of the Docker config file
+func queryAuths(authident string) (creds DockerCreds, error error) {
+	key := "auths." + authident + "."
+
+	if credsb64 := viper.GetString(key + "auth"); credsb64 != "" {
+		credsclearb, err := base64.StdEncoding.DecodeString(credsb64)
+		if err != nil {
+			log.Debug(err)
+			return creds, fmt.Errorf("Error parsing auth info from the Docker config file. Check your local Docker config. ")
+		}
+		credsclear := string(credsclearb)
+		i := strings.Index(credsclear, ":")
+		if i < 0 {
+			return creds, fmt.Errorf("Error parsing auth info from the Docker config file. Check your local Docker config. ")
+		}
+		creds.Username = credsclear[:i]
+		creds.Password = credsclear[i+1:]
+	}
+
+	// OAuth/SSO logins store an identity token (or a registry token) instead of user/password
+	creds.IdentityToken = viper.GetString(key + "identitytoken")
+	creds.RegistryToken = viper.GetString(key + "registrytoken")
+	if creds.Username == "" {
+		creds.Username = viper.GetString(key + "username")
+	}
+
+	if creds.Empty() {
+		return creds, fmt.Errorf("no Docker credentials found for this server/provider. Run 'docker login' first. ")
+	}
+	if creds.IdentityToken != "" || creds.RegistryToken != "" {
+		log.Debug("found token login for ", authident, " in the Docker config file")
+	}
+
+	return creds, nil
 }
 
 //FindReadmeFile trys to find a readme file in the cwd
 func FindReadmeFile() (foundfile string, error error) {
-	preferedfilenames := []string{"./README-containers.md", "./README.md"} //prefer these filenames in this order
+	return FindReadmeFileIn(".")
+}
+
+//FindReadmeFileIn trys to find a readme file in a directory
+func FindReadmeFileIn(dir string) (foundfile string, error error) {
+	//prefer these filenames in this order (templates take precedence over the file they might render to)
+	preferedfilenames := []string{dir + "/README-containers.md" + TemplateFileSuffix, dir + "/README-containers.md", dir + "/README.md" + TemplateFileSuffix, dir + "/README.md"}
 
 	for _, preferedfilename := range preferedfilenames {
 		matches, err := filepath.Glob(preferedfilename)
@@ -204,12 +414,15 @@ func FindReadmeFile() (foundfile string, error error) {
 	}
 
 	if foundfile == "" {
-		matches, err := filepath.Glob("./[R|r][E|e][A|a][D|d][M|m][E|e]*")
+		matches, err := filepath.Glob(dir + "/[R|r][E|e][A|a][D|d][M|m][E|e]*")
 		if err != nil {
 			log.Debug(err)
 			return "", fmt.Errorf("error while searching for alternate readme file")
 		}
 		if len(matches) < 1 {
+			if dir != "." {
+				return "", fmt.Errorf("README file not found in directory %s. Create a file \"README-containers.md\" or \"README.md\" there. ", dir)
+			}
 			return "", fmt.Errorf("README file not found in the current working directory. Create a file \"README-containers.md\" or \"README.md\" or \"cd\" into a directory that contains a README file. ")
 		} else {
 			foundfile = matches[0]
@@ -0,0 +1,98 @@
+/*
+Copyright © 2020 Christian Korneck <christian@korneck.de>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package util
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestConvertToHostname(t *testing.T) {
+	tests := []struct {
+		registryURL string
+		want        string
+	}{
+		{"https://index.docker.io/v1/", "index.docker.io"},
+		{"registry.example.com/v2/", "registry.example.com"},
+		{"Registry.Example.COM", "registry.example.com"},
+		{"https://harbor.local:8443/v2/", "harbor.local:8443"},
+		{"https://[::1]:5000/v2/", "[::1]:5000"},
+		{"http://[2001:DB8::1]/", "[2001:db8::1]"},
+	}
+	for _, tt := range tests {
+		if got := ConvertToHostname(tt.registryURL); got != tt.want {
+			t.Errorf("ConvertToHostname(%q) = %q, want %q", tt.registryURL, got, tt.want)
+		}
+	}
+}
+
+func TestHostPortHelpers(t *testing.T) {
+	if got := EnvSuffix("[::1]:5000"); got != "___1__5000" {
+		t.Errorf("EnvSuffix: got %q", got)
+	}
+	if got := BaseURL("[::1]:5000"); got != "https://[::1]:5000" {
+		t.Errorf("BaseURL: got %q", got)
+	}
+}
+
+func TestFindAuthsKeys(t *testing.T) {
+	viper.Set("auths", map[string]interface{}{
+		"https://harbor.local:8443/v2/": map[string]interface{}{},
+		"harbor.local:8443":             map[string]interface{}{},
+		"harbor.local":                  map[string]interface{}{},
+		"http://HARBOR.local:8443":      map[string]interface{}{},
+	})
+	defer viper.Set("auths", nil)
+
+	// viper lowercases the keys
+	want := []string{"harbor.local:8443", "http://harbor.local:8443", "https://harbor.local:8443/v2/"}
+	if got := findAuthsKeys("harbor.local:8443"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCredHelperFor(t *testing.T) {
+	viper.Set("credHelpers", map[string]string{
+		"quay.io":                      "quay-helper",
+		"https://harbor.local:8443/v2": "harbor-helper",
+	})
+	defer viper.Set("credHelpers", nil)
+
+	tests := []struct {
+		authident string
+		want      string
+	}{
+		{"Quay.IO", "quay-helper"},
+		{"https://quay.io", "quay-helper"},
+		{"harbor.local:8443", "harbor-helper"},
+		{"https://harbor.local:8443/", "harbor-helper"},
+		{"harbor.local", ""},
+	}
+	for _, tt := range tests {
+		if got := credHelperFor(tt.authident); got != tt.want {
+			t.Errorf("credHelperFor(%q) = %q, want %q", tt.authident, got, tt.want)
+		}
+	}
+}